@@ -0,0 +1,33 @@
+// mockoidc 本地开发和测试用的 OpenID Connect 身份提供方，实现见 internal/mockoidc
+//
+// 不校验密码，在授权页面填写邮箱即可登录；带 login_hint 参数时直接以该邮箱登录，方便脚本测试。
+// 配置示例：
+//
+//	oidc:
+//	  - name: mock
+//	    display_name: 测试登录
+//	    issuer: http://localhost:9999
+//	    client_id: card-authorization
+//	    client_secret: secret
+package main
+
+import (
+	"card-authorization/internal/mockoidc"
+	"flag"
+	"log"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9999", "监听地址")
+	issuer := flag.String("issuer", "http://localhost:9999", "issuer，需和应用配置一致")
+	secret := flag.String("client-secret", "", "客户端密钥，为空时不校验")
+	flag.Parse()
+
+	p, err := mockoidc.New(*issuer, *secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("mock OIDC provider %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"card-authorization/database"
+	"card-authorization/models"
+	"fmt"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+const commandUsage = `用法:
+  card-authorization                       启动服务
+  card-authorization promote <用户名> [角色]  设置用户角色，角色为 user、moderator 或 admin，默认 admin`
+
+// runCommand 执行命令行管理命令，返回进程退出码
+// 部署后用 promote 设置第一个管理员，之后可以在管理接口中修改其他用户的角色
+func runCommand(args []string) int {
+	switch args[0] {
+	case "promote":
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Fprintln(os.Stderr, commandUsage)
+			return 2
+		}
+		role := models.RoleAdmin
+		if len(args) == 3 {
+			role = models.Role(args[2])
+		}
+		if err := promote(args[1], role); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("已将用户 %s 的角色设置为 %s\n", args[1], role)
+		return 0
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+}
+
+func promote(username string, role models.Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("未知的角色: %s", role)
+	}
+	var user models.User
+	result := database.DB.Where("username = ?", username).Limit(1).Find(&user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("用户不存在: %s", username)
+	}
+	if user.Role == role {
+		return nil
+	}
+	// 命令行操作没有操作人，修改记录的 actor_id 为0
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("role", role).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserAudit{
+			UserID:   user.ID,
+			Field:    "role",
+			OldValue: string(user.Role),
+			NewValue: string(role),
+		}).Error
+	})
+}
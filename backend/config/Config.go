@@ -2,21 +2,100 @@ package config
 
 import (
 	"card-authorization/log"
+	"crypto/rand"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"strings"
 )
 
 var SystemConfig Config
 
 type Config struct {
 	HTTPPort    string `yaml:"http_port"`
+	PublicURL   string `yaml:"public_url"` // 对外访问地址，用于邮件中的链接
+	SecretKey   string `yaml:"secret_key"` // 签名邮件链接等使用的密钥
 	EmailConfig struct {
 		SMTPHost     string `yaml:"smtp_host"`
 		SMTPPort     int    `yaml:"smtp_port"` // 改为 int 以匹配 YAML
 		AuthEmail    string `yaml:"auth_email"`
 		AuthPassword string `yaml:"auth_password"`
+		TemplateDir  string `yaml:"template_dir"` // 自定义邮件模板目录，为空时使用内置模板
+		// 投递方式：tls（465端口隐式TLS，默认）、starttls（587端口）、plain（不加密，本地中继）、
+		// file（不连接服务器，把邮件写成 .eml 文件到 sink_dir，开发和测试使用）
+		Transport      string `yaml:"transport"`
+		TimeoutSeconds int    `yaml:"timeout_seconds"` // 连接和每封邮件的读写超时
+		PoolSize       int    `yaml:"pool_size"`       // 保持的空闲连接数，0 表示默认值
+		SinkDir        string `yaml:"sink_dir"`        // file 方式的maildir目录，邮件写入其中的 new/
+		// 收件地址连续被服务器拒收多少次后标记为无法投递
+		UndeliverableAfter int `yaml:"undeliverable_after"`
 	} `yaml:"email"`
+	// 邮件发件箱，邮件先落库再由后台投递
+	Outbox struct {
+		Workers          int `yaml:"workers"`            // 投递协程数
+		MaxAttempts      int `yaml:"max_attempts"`       // 最大尝试次数，超过后进入死信
+		BaseDelaySeconds int `yaml:"base_delay_seconds"` // 首次重试等待秒数，之后按指数递增
+		MaxDelaySeconds  int `yaml:"max_delay_seconds"`  // 重试等待上限
+	} `yaml:"outbox"`
+	// 关闭用户名密码登录，只能通过邮件链接或第三方登录
+	DisablePasswordLogin bool `yaml:"disable_password_login"`
+	// 注销用户持有的别人送的卡：return 退回创造者（默认），expire 直接过期，transfer 转给注销时指定的用户
+	DeletedUserCards string `yaml:"deleted_user_cards"`
+	// 第三方登录，回调地址为 public_url + /api/oidc/{name}/callback
+	OIDC []OIDCProvider `yaml:"oidc"`
+	// 限流策略，按名称在路由上引用，未配置的使用默认值
+	RateLimits map[string]RateLimitPolicy `yaml:"rate_limits"`
+	// 登录token的签名密钥，可以同时配置多个，按token头中的 kid 选择验证用的密钥
+	JWT struct {
+		SigningKID       string   `yaml:"signing_kid"`        // 签发新token使用的密钥，为空时使用第一个可签发的密钥
+		AccessTTLMinutes int      `yaml:"access_ttl_minutes"` // 访问token有效期，过期后用刷新token换新的
+		RefreshTTLDays   int      `yaml:"refresh_ttl_days"`   // 会话闲置超过该天数需要重新登录
+		Keys             []JWTKey `yaml:"keys"`
+	} `yaml:"jwt"`
+	Notifier string `yaml:"notifier"` // 通知投递方式：smtp（默认）或 log（开发环境只写日志）
+	// webhook默认不能指向本机和内网，需要推送到内网服务时在这里放行，如 192.168.1.10 或 10.0.0.0/8
+	WebhookAllowedNetworks []string `yaml:"webhook_allowed_networks"`
+}
+
+// JWTKey 一个签名密钥
+// 轮换时先加入新密钥并切换 signing_kid，旧密钥保留到它签发的token全部过期再删除；
+// 非对称密钥可以只保留公钥，只用于验证
+type JWTKey struct {
+	KID            string `yaml:"kid"`
+	Algorithm      string `yaml:"algorithm"`        // HS256（默认）、RS256 或 EdDSA
+	Secret         string `yaml:"secret"`           // HS256 的密钥
+	PrivateKeyFile string `yaml:"private_key_file"` // RS256/EdDSA 的PEM私钥（PKCS#8，RSA也可以是PKCS#1）
+	PublicKeyFile  string `yaml:"public_key_file"`  // 只配置公钥时该密钥只用于验证
+}
+
+// OIDCProvider 一个 OpenID Connect 身份提供方
+type OIDCProvider struct {
+	Name         string   `yaml:"name"`         // 路由中使用的标识，如 google
+	DisplayName  string   `yaml:"display_name"` // 登录按钮上显示的名称
+	Issuer       string   `yaml:"issuer"`       // 从 issuer + /.well-known/openid-configuration 获取端点
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"` // 默认 openid email profile
+}
+
+// RateLimitPolicy 令牌桶限流策略：每 PeriodSeconds 秒补充 Requests 个令牌，桶中最多 Burst 个
+type RateLimitPolicy struct {
+	Requests      int `yaml:"requests"`
+	PeriodSeconds int `yaml:"period_seconds"`
+	Burst         int `yaml:"burst"` // 允许的突发请求数，为0时等于 Requests
+}
+
+// defaultRateLimits 默认的限流策略
+var defaultRateLimits = map[string]RateLimitPolicy{
+	"api":             {Requests: 300, PeriodSeconds: 60},  // 登录后的所有接口
+	"register":        {Requests: 10, PeriodSeconds: 3600}, // 注册
+	"password_forgot": {Requests: 5, PeriodSeconds: 900},   // 申请重置密码，会发邮件
+	"password_reset":  {Requests: 10, PeriodSeconds: 900},  // 重置密码
+	"magic_link":      {Requests: 5, PeriodSeconds: 900},   // 申请登录链接，会发邮件
+	"friend_search":   {Requests: 30, PeriodSeconds: 60},   // 搜索用户
+	"friend_invite":   {Requests: 10, PeriodSeconds: 3600}, // 邀请道友，会发邮件
+	"card_send":       {Requests: 60, PeriodSeconds: 3600, Burst: 20},
+	"card_copy":       {Requests: 30, PeriodSeconds: 60},
 }
 
 // LoadConfig 加载外部配置文件
@@ -37,5 +116,114 @@ func LoadConfig() error {
 	log.Info("SMTP Port: %d", SystemConfig.EmailConfig.SMTPPort)
 	log.Info("Auth Email: %s", SystemConfig.EmailConfig.AuthEmail)
 	log.Info("Auth Password: %s", "******")
+	setDefaults()
+	log.Info("Email Transport: %s", SystemConfig.EmailConfig.Transport)
 	return nil
 }
+
+// setDefaults 未配置的项使用默认值
+func setDefaults() {
+	if SystemConfig.PublicURL == "" {
+		// 邮件中的链接会指向本机，收件人无法打开，生产环境必须配置
+		SystemConfig.PublicURL = "http://localhost:" + SystemConfig.HTTPPort
+		log.Warn("未配置 public_url，邮件中的链接将使用 %s", SystemConfig.PublicURL)
+	}
+	SystemConfig.PublicURL = strings.TrimRight(SystemConfig.PublicURL, "/")
+	if SystemConfig.SecretKey == "" {
+		// 未配置时随机生成，重启后之前发出的链接会失效
+		log.Warn("未配置 secret_key，已随机生成，重启后邮件中的链接将失效")
+		key := make([]byte, 32)
+		rand.Read(key)
+		SystemConfig.SecretKey = fmt.Sprintf("%x", key)
+	}
+	switch SystemConfig.EmailConfig.Transport {
+	case "":
+		SystemConfig.EmailConfig.Transport = "tls"
+	case "tls", "starttls", "plain", "file":
+	default:
+		log.Warn("未知的邮件投递方式 %s，使用 tls", SystemConfig.EmailConfig.Transport)
+		SystemConfig.EmailConfig.Transport = "tls"
+	}
+	if SystemConfig.EmailConfig.TimeoutSeconds <= 0 {
+		SystemConfig.EmailConfig.TimeoutSeconds = 15
+	}
+	if SystemConfig.EmailConfig.PoolSize <= 0 {
+		SystemConfig.EmailConfig.PoolSize = 2
+	}
+	if SystemConfig.EmailConfig.UndeliverableAfter <= 0 {
+		SystemConfig.EmailConfig.UndeliverableAfter = 3
+	}
+	if SystemConfig.EmailConfig.SinkDir == "" {
+		SystemConfig.EmailConfig.SinkDir = "mail"
+	}
+	// 环境变量中的密钥优先，避免把密钥写进配置文件
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		kid := os.Getenv("JWT_KID")
+		if kid == "" {
+			kid = "env"
+		}
+		SystemConfig.JWT.Keys = append([]JWTKey{{KID: kid, Algorithm: "HS256", Secret: secret}}, SystemConfig.JWT.Keys...)
+	}
+	if kid := os.Getenv("JWT_SIGNING_KID"); kid != "" {
+		SystemConfig.JWT.SigningKID = kid
+	}
+	if SystemConfig.JWT.AccessTTLMinutes <= 0 {
+		SystemConfig.JWT.AccessTTLMinutes = 15
+	}
+	if SystemConfig.JWT.RefreshTTLDays <= 0 {
+		SystemConfig.JWT.RefreshTTLDays = 30
+	}
+	for i := range SystemConfig.OIDC {
+		provider := &SystemConfig.OIDC[i]
+		if provider.DisplayName == "" {
+			provider.DisplayName = provider.Name
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+	switch SystemConfig.DeletedUserCards {
+	case "":
+		SystemConfig.DeletedUserCards = "return"
+	case "return", "expire", "transfer":
+	default:
+		log.Warn("未知的注销卡片处理方式 %s，使用 return", SystemConfig.DeletedUserCards)
+		SystemConfig.DeletedUserCards = "return"
+	}
+	if SystemConfig.RateLimits == nil {
+		SystemConfig.RateLimits = map[string]RateLimitPolicy{}
+	}
+	for name, policy := range defaultRateLimits {
+		if _, ok := SystemConfig.RateLimits[name]; !ok {
+			SystemConfig.RateLimits[name] = policy
+		}
+	}
+	for name, policy := range SystemConfig.RateLimits {
+		if policy.Requests <= 0 || policy.PeriodSeconds <= 0 {
+			def, ok := defaultRateLimits[name]
+			if !ok {
+				log.Warn("限流策略 %s 配置无效，已忽略", name)
+				delete(SystemConfig.RateLimits, name)
+				continue
+			}
+			log.Warn("限流策略 %s 配置无效，使用默认值", name)
+			policy = def
+		}
+		if policy.Burst <= 0 {
+			policy.Burst = policy.Requests
+		}
+		SystemConfig.RateLimits[name] = policy
+	}
+	if SystemConfig.Outbox.Workers <= 0 {
+		SystemConfig.Outbox.Workers = 2
+	}
+	if SystemConfig.Outbox.MaxAttempts <= 0 {
+		SystemConfig.Outbox.MaxAttempts = 6
+	}
+	if SystemConfig.Outbox.BaseDelaySeconds <= 0 {
+		SystemConfig.Outbox.BaseDelaySeconds = 30
+	}
+	if SystemConfig.Outbox.MaxDelaySeconds <= 0 {
+		SystemConfig.Outbox.MaxDelaySeconds = 3600
+	}
+}
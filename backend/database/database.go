@@ -10,8 +10,14 @@ import (
 var DB *gorm.DB
 
 func InitDB() error {
+	// 后台投递协程会并发写库，设置忙等待避免 database is locked
+	return Open("card_authorization.db?_pragma=busy_timeout(5000)")
+}
+
+// Open 打开指定的sqlite数据库并迁移表结构，测试时使用临时文件
+func Open(dsn string) error {
 	var err error
-	DB, err = gorm.Open(sqlite.Open("card_authorization.db"), &gorm.Config{})
+	DB, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return err
 	}
@@ -23,6 +29,23 @@ func InitDB() error {
 		&models.FriendInvite{},
 		&models.Card{},
 		&models.CardTransaction{},
+		&models.Activity{},
+		&models.Notification{},
+		&models.OutboxMessage{},
+		&models.Webhook{},
+		&models.NotificationPreference{},
+		&models.NotificationSetting{},
+		&models.EmailDelivery{},
+		&models.EmailBounce{},
+		&models.PasswordResetToken{},
+		&models.UserAudit{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.RecoveryCode{},
+		&models.SecurityEvent{},
+		&models.PersonalAccessToken{},
+		&models.UserIdentity{},
+		&models.MagicLinkToken{},
 	)
 	if err != nil {
 		return err
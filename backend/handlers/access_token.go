@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/utils"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	maxAccessTokensPerUser = 20
+	defaultAccessTokenDays = 30
+	maxAccessTokenDays     = 365
+)
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=64"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days"` // 不填为30天，最长365天
+}
+
+// ListAccessTokens 我的个人访问令牌，不包括已撤销的
+func ListAccessTokens(c *gin.Context) {
+	var tokens []models.PersonalAccessToken
+	if err := database.DB.Where("user_id = ? AND revoked_at IS NULL", c.GetUint("userID")).
+		Order("id DESC").Find(&tokens).Error; err != nil {
+		log.Error("获取个人访问令牌失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取个人访问令牌失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens, "scopes": models.AccessTokenScopes})
+}
+
+// CreateAccessToken 创建个人访问令牌，令牌只在创建时返回一次
+func CreateAccessToken(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var req CreateAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var scopes []string
+	for _, scope := range req.Scopes {
+		scope = strings.TrimSpace(scope)
+		if !slices.Contains(models.AccessTokenScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未知的权限: " + scope})
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = defaultAccessTokenDays
+	}
+	if days < 0 || days > maxAccessTokenDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "有效期需在1到365天之间"})
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Count(&count)
+	if count >= maxAccessTokensPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "个人访问令牌数量已达上限"})
+		return
+	}
+
+	raw := models.AccessTokenPrefix + utils.RandomToken()
+	token := &models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: utils.HashToken(raw),
+		Hint:      raw[:len(models.AccessTokenPrefix)+6],
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: time.Now().AddDate(0, 0, days),
+	}
+	if err := database.DB.Create(token).Error; err != nil {
+		log.Error("创建个人访问令牌失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建个人访问令牌失败"})
+		return
+	}
+	log.Info("用户[%d]创建了个人访问令牌[%d] %s", userID, token.ID, token.Scopes)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "创建成功，请妥善保存令牌，关闭后无法再次查看",
+		"access_token": token,
+		"token":        raw,
+	})
+}
+
+// RevokeAccessToken 撤销个人访问令牌
+func RevokeAccessToken(c *gin.Context) {
+	result := database.DB.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", c.Param("id"), c.GetUint("userID")).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		log.Error("撤销个人访问令牌失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销个人访问令牌失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "个人访问令牌不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "个人访问令牌已撤销"})
+}
+
+// revokeAccessTokens 撤销用户的全部个人访问令牌
+func revokeAccessTokens(db *gorm.DB, userID uint) error {
+	return db.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"` // 不填时要求刚登录过
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+// ProfileUpdateRequest 可以修改的资料字段，只传需要修改的字段，未列出的字段一律不处理
+type ProfileUpdateRequest struct {
+	Username *string `json:"username"`
+	Nickname *string `json:"nickname"`
+	Email    *string `json:"email"`
+	Locale   *string `json:"locale"` // zh-CN 或 en
+
+	CurrentPassword string `json:"current_password"` // 自己修改邮箱时，不是刚登录过就必填
+}
+
+// 用户名：2-32位字母、数字、汉字或下划线
+var usernamePattern = regexp.MustCompile(`^[\p{L}\p{N}_]{2,32}$`)
+
+// ChangePassword 校验当前密码后修改密码，刚登录过的会话可以不输入当前密码
+// 其他设备上的登录全部失效，当前设备使用返回的新token继续登录
+func ChangePassword(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if req.CurrentPassword != "" {
+		if !user.CheckPassword(req.CurrentPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "当前密码错误"})
+			return
+		}
+	} else if !recentlyLoggedIn(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "请输入当前密码，或重新登录后再修改密码", "reauth_required": true})
+		return
+	}
+	if user.CheckPassword(req.NewPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "新密码不能与当前密码相同"})
+		return
+	}
+
+	hashedPassword, err := models.HashPassword(req.NewPassword)
+	if err != nil {
+		log.Error("加密密码失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改密码失败"})
+		return
+	}
+	if err := database.DB.Model(&user).Update("password", hashedPassword).Error; err != nil {
+		log.Error("修改密码失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改密码失败"})
+		return
+	}
+
+	// 其他设备上的登录全部失效，当前会话保留
+	if err := revokeSessions(database.DB, user.ID, c.GetUint("sessionID"), models.SessionRevokedPasswordChange); err != nil {
+		log.Error("注销会话失败: %v", err)
+	}
+	log.Info("用户[%d]修改了密码", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "密码修改成功"})
+}
+
+// UpdateAccountSettings 修改自己的用户名、昵称、邮箱和邮件语言
+func UpdateAccountSettings(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var req ProfileUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updateProfile(c, userID, userID, &req)
+}
+
+// updateProfile 校验并保存资料修改，每个修改的字段记一条审计记录
+// 自己修改邮箱需要输入当前密码，修改后需要重新验证，并通知原邮箱
+func updateProfile(c *gin.Context, actorID, userID uint, req *ProfileUpdateRequest) {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	// 按字段顺序记录修改，审计记录也按这个顺序写入
+	type change struct{ field, oldValue, newValue string }
+	var changes []change
+
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if !usernamePattern.MatchString(username) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "用户名应为2-32位字母、数字、汉字或下划线"})
+			return
+		}
+		if username != user.Username {
+			var count int64
+			database.DB.Model(&models.User{}).Where("username = ? AND id <> ?", username, user.ID).Count(&count)
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "用户名已存在"})
+				return
+			}
+			changes = append(changes, change{"username", user.Username, username})
+		}
+	}
+	if req.Nickname != nil {
+		nickname := strings.TrimSpace(*req.Nickname)
+		if nickname == "" || len([]rune(nickname)) > 32 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "昵称不能为空且不超过32个字"})
+			return
+		}
+		if nickname != user.Nickname {
+			changes = append(changes, change{"nickname", user.Nickname, nickname})
+		}
+	}
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "邮箱格式错误"})
+			return
+		}
+		if email != user.Email {
+			var count int64
+			database.DB.Model(&models.User{}).Where("email = ? AND id <> ?", email, user.ID).Count(&count)
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "邮箱已存在"})
+				return
+			}
+			// 管理员修改他人邮箱不需要密码
+			if actorID == user.ID {
+				if req.CurrentPassword != "" {
+					if !user.CheckPassword(req.CurrentPassword) {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "修改邮箱需要输入正确的当前密码"})
+						return
+					}
+				} else if !recentlyLoggedIn(c) {
+					c.JSON(http.StatusForbidden, gin.H{"error": "请输入当前密码，或重新登录后再修改邮箱", "reauth_required": true})
+					return
+				}
+			}
+			changes = append(changes, change{"email", user.Email, email})
+		}
+	}
+	if req.Locale != nil {
+		if locale := notify.NormalizeLocale(*req.Locale); locale != user.Locale {
+			changes = append(changes, change{"locale", user.Locale, locale})
+		}
+	}
+	if len(changes) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "没有需要修改的内容", "user": user})
+		return
+	}
+
+	updates := map[string]any{}
+	oldUser := user
+	emailChanged := false
+	for _, ch := range changes {
+		updates[ch.field] = ch.newValue
+		if ch.field == "email" {
+			updates["email_verified_at"] = nil
+			emailChanged = true
+		}
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		for _, ch := range changes {
+			if err := tx.Create(&models.UserAudit{
+				UserID:   user.ID,
+				ActorID:  actorID,
+				Field:    ch.field,
+				OldValue: ch.oldValue,
+				NewValue: ch.newValue,
+				IP:       c.ClientIP(),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("更新用户资料失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新用户资料失败"})
+		return
+	}
+	database.DB.First(&user, userID)
+
+	message := "用户信息更新成功"
+	if emailChanged {
+		if err := sendVerificationEmail(&user); err != nil {
+			log.Error("发送验证邮件失败: %v", err)
+		}
+		sendEmailChangedNotice(c, &oldUser, user.Email)
+		message = "用户信息更新成功，请查收新邮箱的验证邮件"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "user": user})
+}
+
+// sendEmailChangedNotice 告知原邮箱账号邮箱已被修改，被盗号时原主人还能及时发现
+func sendEmailChangedNotice(c *gin.Context, oldUser *models.User, newEmail string) {
+	if err := notify.SendAccountEmail(oldUser, "email_changed", map[string]any{
+		"Nickname":  oldUser.Nickname,
+		"NewEmail":  newEmail,
+		"IP":        c.ClientIP(),
+		"Time":      time.Now().Format("2006-01-02 15:04:05"),
+		"ActionURL": config.SystemConfig.PublicURL + "/dashboard",
+	}); err != nil {
+		log.Error("发送邮箱修改提醒失败: %v", err)
+	}
+}
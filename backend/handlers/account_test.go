@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// withSession 模拟通过某个会话的token访问
+func withSession(handler gin.HandlerFunc, sessionID uint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("sessionID", sessionID)
+		handler(c)
+	}
+}
+
+func TestChangePasswordWithoutCurrentPasswordNeedsRecentLogin(t *testing.T) {
+	user := createTestUser(t, "pwd_recent")
+	session := models.Session{UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := database.DB.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	body := gin.H{"new_password": "654321"}
+
+	database.DB.Model(&session).UpdateColumn("created_at", time.Now().Add(-recentLoginWindow-time.Minute))
+	w := serve(withSession(ChangePassword, session.ID), user.ID, http.MethodPost, "/api/account/password", "/api/account/password", body)
+	if w.Code != http.StatusForbidden || decodeJSON(t, w)["reauth_required"] != true {
+		t.Fatalf("old session: ChangePassword = %d %s", w.Code, w.Body.String())
+	}
+
+	database.DB.Model(&session).UpdateColumn("created_at", time.Now())
+	w = serve(withSession(ChangePassword, session.ID), user.ID, http.MethodPost, "/api/account/password", "/api/account/password", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("recent session: ChangePassword = %d %s", w.Code, w.Body.String())
+	}
+	database.DB.First(user, user.ID)
+	if !user.CheckPassword("654321") {
+		t.Error("password was not changed")
+	}
+}
+
+func TestChangeEmailWithoutCurrentPasswordNeedsRecentLogin(t *testing.T) {
+	user := createTestUser(t, "email_recent")
+	session := models.Session{UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := database.DB.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	body := gin.H{"email": testEmail(user.Username + "_new")}
+
+	database.DB.Model(&session).UpdateColumn("created_at", time.Now().Add(-recentLoginWindow-time.Minute))
+	w := serve(withSession(UpdateAccountSettings, session.ID), user.ID, http.MethodPost, "/api/account/settings", "/api/account/settings", body)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("old session: UpdateAccountSettings = %d %s", w.Code, w.Body.String())
+	}
+
+	database.DB.Model(&session).UpdateColumn("created_at", time.Now())
+	w = serve(withSession(UpdateAccountSettings, session.ID), user.ID, http.MethodPost, "/api/account/settings", "/api/account/settings", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("recent session: UpdateAccountSettings = %d %s", w.Code, w.Body.String())
+	}
+}
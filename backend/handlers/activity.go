@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultActivityLimit = 20
+	maxActivityLimit     = 100
+)
+
+// ListActivities 我的动态
+// 查询参数：
+//   - type: 事件类型，多个用逗号分隔，如 card_send,card_use
+//   - counterparty_id: 只看与某个用户相关的动态
+//   - before: 分页游标，返回ID小于该值的更早动态（按时间倒序）
+//   - since: 增量同步游标，返回ID大于该值的新动态（按时间正序）
+//   - limit: 每页数量，默认20，最大100
+func ListActivities(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	limit := defaultActivityLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit参数无效"})
+			return
+		}
+		limit = min(n, maxActivityLimit)
+	}
+
+	query := database.DB.Where("user_id = ?", userID)
+
+	if v := c.Query("type"); v != "" {
+		var types []models.ActivityType
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, models.ActivityType(t))
+			}
+		}
+		if len(types) > 0 {
+			query = query.Where("type IN ?", types)
+		}
+	}
+
+	if v := c.Query("counterparty_id"); v != "" {
+		counterpartyID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "counterparty_id参数无效"})
+			return
+		}
+		query = query.Where("counterparty_id = ?", counterpartyID)
+	}
+
+	since := c.Query("since")
+	before := c.Query("before")
+	if since != "" && before != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since和before不能同时使用"})
+		return
+	}
+	if since != "" {
+		sinceID, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since参数无效"})
+			return
+		}
+		query = query.Where("id > ?", sinceID).Order("id ASC")
+	} else {
+		if before != "" {
+			beforeID, err := strconv.ParseUint(before, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "before参数无效"})
+				return
+			}
+			query = query.Where("id < ?", beforeID)
+		}
+		query = query.Order("id DESC")
+	}
+
+	// 多取一条用来判断是否还有更多数据
+	var activities []models.Activity
+	if err := query.Limit(limit + 1).Find(&activities).Error; err != nil {
+		log.Error("获取动态失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取动态失败"})
+		return
+	}
+	hasMore := len(activities) > limit
+	if hasMore {
+		activities = activities[:limit]
+	}
+
+	resp := gin.H{
+		"activities": activities,
+		"has_more":   hasMore,
+	}
+	if len(activities) > 0 {
+		last := activities[len(activities)-1].ID
+		if since != "" {
+			// 增量同步时，客户端下次以此作为since继续拉取
+			resp["next_since"] = last
+		} else {
+			resp["next_before"] = last
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// recordCardActivity 记录卡片动态，from 和 to 各写一条（相同时只写一条）
+func recordCardActivity(activityType models.ActivityType, actorID uint, card *models.Card, from, to *models.User) {
+	recordCardActivityAt(time.Now(), activityType, actorID, card, from, to)
+}
+
+func recordCardActivityAt(at time.Time, activityType models.ActivityType, actorID uint, card *models.Card, from, to *models.User) {
+	activities, err := createCardActivities(database.DB, at, activityType, actorID, card, from, to)
+	if err != nil {
+		log.Error("记录动态失败: %v", err)
+		return
+	}
+	publishActivities(activities)
+}
+
+// createCardActivities 在 db 中写入卡片动态但不推送，事务中使用，提交后再调用 publishActivities
+func createCardActivities(db *gorm.DB, at time.Time, activityType models.ActivityType, actorID uint, card *models.Card, from, to *models.User) ([]models.Activity, error) {
+	creator := card.Creator
+	if creator.ID != card.CreatorID {
+		db.Unscoped().First(&creator, card.CreatorID)
+	}
+	payload := models.CardActivityPayload{
+		CardID:          card.ID,
+		CardTitle:       card.Title,
+		CardDescription: card.Description,
+		CreatorID:       card.CreatorID,
+		CreatorNickname: creator.Nickname,
+		FromUserID:      from.ID,
+		FromNickname:    from.Nickname,
+		ToUserID:        to.ID,
+		ToNickname:      to.Nickname,
+	}
+	return createActivities(db, at, activityType, actorID, &card.ID, from.ID, to.ID, payload)
+}
+
+// recordFriendActivity 记录道友动态，双方各写一条
+func recordFriendActivity(activityType models.ActivityType, from, to *models.User) {
+	payload := models.FriendActivityPayload{
+		FromUserID:   from.ID,
+		FromNickname: from.Nickname,
+		ToUserID:     to.ID,
+		ToNickname:   to.Nickname,
+	}
+	activities, err := createActivities(database.DB, time.Now(), activityType, from.ID, nil, from.ID, to.ID, payload)
+	if err != nil {
+		log.Error("记录动态失败: %v", err)
+		return
+	}
+	publishActivities(activities)
+}
+
+func createActivities(db *gorm.DB, at time.Time, activityType models.ActivityType, actorID uint, cardID *uint, fromID, toID uint, payload any) ([]models.Activity, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("序列化动态失败: %w", err)
+	}
+	activities := []models.Activity{{
+		UserID:         fromID,
+		ActorID:        actorID,
+		CounterpartyID: counterpartyOf(fromID, toID),
+		Type:           activityType,
+		CardID:         cardID,
+		Payload:        data,
+		CreatedAt:      at,
+	}}
+	if toID != fromID {
+		activities = append(activities, models.Activity{
+			UserID:         toID,
+			ActorID:        actorID,
+			CounterpartyID: fromID,
+			Type:           activityType,
+			CardID:         cardID,
+			Payload:        data,
+			CreatedAt:      at,
+		})
+	}
+	if err := db.Create(&activities).Error; err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// publishActivities 把已保存的动态推送给在线的用户
+func publishActivities(activities []models.Activity) {
+	for i := range activities {
+		notify.Publish(activities[i].UserID, activityEvent(&activities[i]))
+	}
+}
+
+func counterpartyOf(fromID, toID uint) uint {
+	if fromID == toID {
+		return 0
+	}
+	return toID
+}
+
+// BackfillActivities 动态表为空时，根据已有的卡片和交易记录补齐历史动态
+func BackfillActivities() {
+	var count int64
+	if err := database.DB.Model(&models.Activity{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	// 先收集再按时间排序写入，保证动态ID与时间顺序一致
+	type pending struct {
+		at     time.Time
+		record func(at time.Time)
+	}
+	var events []pending
+
+	var cards []models.Card
+	database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).Find(&cards)
+	cardMap := make(map[uint]*models.Card, len(cards))
+	for i := range cards {
+		card := &cards[i]
+		cardMap[card.ID] = card
+		events = append(events, pending{card.CreatedAt, func(at time.Time) {
+			recordCardActivityAt(at, models.ActivityCardCreate, card.CreatorID, card, &card.Creator, &card.Creator)
+		}})
+		// 过期没有流转记录，按过期时间补一条，注销时提前失效的卡没有到期时间，用最后修改时间
+		if card.Status == models.CardStatusExpired {
+			at := card.UpdatedAt
+			if card.ExpiresAt != nil && card.ExpiresAt.Before(at) {
+				at = *card.ExpiresAt
+			}
+			events = append(events, pending{at, func(at time.Time) {
+				recordCardActivityAt(at, models.ActivityCardExpire, 0, card, &card.Owner, &card.Creator)
+			}})
+		}
+	}
+
+	var transactions []models.CardTransaction
+	database.DB.Preload("FromUser", models.IncludeDeleted).Preload("ToUser", models.IncludeDeleted).Find(&transactions)
+	for i := range transactions {
+		tx := &transactions[i]
+		card, ok := cardMap[tx.CardID]
+		if !ok {
+			continue
+		}
+		// 与实时记录动态时一致：退回和转交是注销账号时系统做的，按发送记录，没有操作者
+		var activityType models.ActivityType
+		actorID := tx.FromUserID
+		switch tx.Type {
+		case "send":
+			activityType = models.ActivityCardSend
+		case "use":
+			activityType = models.ActivityCardUse
+		case "revoke":
+			activityType = models.ActivityCardRevoke
+		case "return", "transfer":
+			activityType, actorID = models.ActivityCardSend, 0
+		default:
+			log.Warn("补齐动态时跳过未知类型的流转记录[%d]: %s", tx.ID, tx.Type)
+			continue
+		}
+		events = append(events, pending{tx.CreatedAt, func(at time.Time) {
+			recordCardActivityAt(at, activityType, actorID, card, &tx.FromUser, &tx.ToUser)
+		}})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+	for _, e := range events {
+		e.record(e.at)
+	}
+	log.Info("已补齐历史动态")
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestBackfillActivitiesMapsTransactionTypes(t *testing.T) {
+	creator := createTestUser(t, "backfill_creator")
+	holder := createTestUser(t, "backfill_holder")
+	expiresAt := time.Now().Add(-time.Hour)
+	card := models.Card{Title: "backfill", CreatorID: creator.ID, OwnerID: creator.ID,
+		Status: models.CardStatusExpired, ExpiresAt: &expiresAt}
+	if err := database.DB.Create(&card).Error; err != nil {
+		t.Fatal(err)
+	}
+	transactions := []models.CardTransaction{
+		{CardID: card.ID, FromUserID: creator.ID, ToUserID: holder.ID, Type: "send"},
+		{CardID: card.ID, FromUserID: creator.ID, ToUserID: holder.ID, Type: "revoke"},
+		{CardID: card.ID, FromUserID: holder.ID, ToUserID: creator.ID, Type: "return"},
+		{CardID: card.ID, FromUserID: holder.ID, ToUserID: creator.ID, Type: "use"},
+		{CardID: card.ID, FromUserID: holder.ID, ToUserID: creator.ID, Type: "unknown"},
+	}
+	if err := database.DB.Create(&transactions).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 只有动态表为空时才会补齐
+	database.DB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&models.Activity{})
+
+	BackfillActivities()
+
+	var activities []models.Activity
+	database.DB.Where("user_id = ? AND card_id = ?", creator.ID, card.ID).Order("id").Find(&activities)
+	got := map[models.ActivityType]uint{}
+	for _, a := range activities {
+		got[a.Type]++
+	}
+	want := map[models.ActivityType]uint{
+		models.ActivityCardCreate: 1,
+		models.ActivityCardSend:   2,
+		models.ActivityCardRevoke: 1,
+		models.ActivityCardUse:    1,
+		models.ActivityCardExpire: 1,
+	}
+	for activityType, n := range want {
+		if got[activityType] != n {
+			t.Errorf("%s activities = %d, want %d (all: %v)", activityType, got[activityType], n, got)
+		}
+	}
+	if len(activities) != 6 {
+		t.Errorf("got %d activities, want 6", len(activities))
+	}
+}
@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// pageParams 分页参数，page 从1开始，page_size 默认20，最大100
+func pageParams(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return page, pageSize
+}
+
+// ListOutbox 查看发件箱，可按 status 过滤，如 status=dead 查看死信
+func ListOutbox(c *gin.Context) {
+	page, pageSize := pageParams(c)
+
+	query := database.DB.Model(&models.OutboxMessage{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Error("获取发件箱失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取发件箱失败"})
+		return
+	}
+	var messages []models.OutboxMessage
+	if err := query.Order("id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&messages).Error; err != nil {
+		log.Error("获取发件箱失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取发件箱失败"})
+		return
+	}
+
+	// 各状态数量
+	type statusCount struct {
+		Status string `json:"status"`
+		Count  int64  `json:"count"`
+	}
+	var counts []statusCount
+	database.DB.Model(&models.OutboxMessage{}).Select("status, count(*) as count").Group("status").Scan(&counts)
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":  messages,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"counts":    counts,
+	})
+}
+
+// RetryOutbox 立即重试单封邮件
+func RetryOutbox(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "邮件ID无效"})
+		return
+	}
+	ok, err := notify.Retry(uint(id))
+	if err != nil {
+		log.Error("重试邮件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重试邮件失败"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "邮件不存在或不可重试"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "邮件已重新排队"})
+}
+
+// RetryDeadOutbox 重试所有死信邮件
+func RetryDeadOutbox(c *gin.Context) {
+	count, err := notify.RetryDead()
+	if err != nil {
+		log.Error("重试邮件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重试邮件失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "死信邮件已重新排队", "count": count})
+}
+
+// ListEmailBounces 退信统计，undeliverable=1 只看已标记为无法投递的地址
+func ListEmailBounces(c *gin.Context) {
+	query := database.DB.Model(&models.EmailBounce{})
+	if c.Query("undeliverable") == "1" {
+		query = query.Where("undeliverable_at IS NOT NULL")
+	}
+	var bounces []models.EmailBounce
+	if err := query.Order("updated_at DESC").Limit(500).Find(&bounces).Error; err != nil {
+		log.Error("获取退信统计失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取退信统计失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bounces": bounces})
+}
+
+type ClearBounceRequest struct {
+	Address string `json:"address" binding:"required"`
+}
+
+// ClearEmailBounce 解除地址的无法投递标记
+func ClearEmailBounce(c *gin.Context) {
+	var req ClearBounceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ok, err := notify.ClearUndeliverable(req.Address)
+	if err != nil {
+		log.Error("解除无法投递标记失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解除无法投递标记失败"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该地址没有退信记录"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已解除无法投递标记"})
+}
+
+// AdminUpdateUser 管理员修改其他用户的资料
+func AdminUpdateUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID无效"})
+		return
+	}
+	var req ProfileUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updateProfile(c, c.GetUint("userID"), uint(id), &req)
+}
+
+// ListUserAudits 查看用户资料的修改记录
+func ListUserAudits(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID无效"})
+		return
+	}
+	var audits []models.UserAudit
+	if err := database.DB.Where("user_id = ?", id).Order("id DESC").Limit(200).Find(&audits).Error; err != nil {
+		log.Error("获取修改记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取修改记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"audits": audits})
+}
+
+// AdminListUsers 用户列表
+// 查询参数：
+//   - q: 按用户名、昵称或邮箱模糊搜索
+//   - role: 只看某个角色
+//   - status: active、suspended 或 deleted（已注销）
+//   - page、page_size: 分页
+func AdminListUsers(c *gin.Context) {
+	page, pageSize := pageParams(c)
+
+	query := database.DB.Model(&models.User{})
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("username LIKE ? OR nickname LIKE ? OR email LIKE ?", like, like, like)
+	}
+	if role := c.Query("role"); role != "" {
+		if !models.Role(role).Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role参数无效"})
+			return
+		}
+		query = query.Where("role = ?", role)
+	}
+	switch c.Query("status") {
+	case "":
+	case "active":
+		query = query.Where("suspended_at IS NULL")
+	case "suspended":
+		query = query.Where("suspended_at IS NOT NULL")
+	case "deleted":
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status参数无效"})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Error("获取用户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用户失败"})
+		return
+	}
+	var users []models.User
+	if err := query.Order("id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&users).Error; err != nil {
+		log.Error("获取用户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用户失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"users":     users,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// adminTargetUser 读取被管理的用户
+// 不能管理自己，版主也不能管理版主和管理员
+func adminTargetUser(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID无效"})
+		return nil, false
+	}
+	if uint(id) == c.GetUint("userID") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能对自己执行该操作"})
+		return nil, false
+	}
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return nil, false
+	}
+	if !models.Role(c.GetString("userRole")).Includes(models.RoleAdmin) && user.Role.Includes(models.RoleModerator) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能管理同级或更高权限的用户"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// newUserAudit 当前用户对 userID 的一条修改记录
+func newUserAudit(c *gin.Context, userID uint, field, oldValue, newValue string) *models.UserAudit {
+	return &models.UserAudit{
+		UserID:   userID,
+		ActorID:  c.GetUint("userID"),
+		Field:    field,
+		OldValue: oldValue,
+		NewValue: newValue,
+		IP:       c.ClientIP(),
+	}
+}
+
+// updateUserWithAudit 管理员修改用户状态，同时写一条修改记录
+func updateUserWithAudit(c *gin.Context, user *models.User, updates map[string]any, field, oldValue, newValue string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Create(newUserAudit(c, user.ID, field, oldValue, newValue)).Error
+	})
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason" binding:"required,max=200"` // 停用原因，用户登录和调用接口时会看到
+}
+
+// SuspendUser 停用账号，立即注销所有会话，停用期间个人访问令牌也无法使用
+// 修改记录中的值为停用原因，为空表示未停用
+func SuspendUser(c *gin.Context) {
+	var req SuspendUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "账号已经是停用状态"})
+		return
+	}
+	now := time.Now()
+	updates := map[string]any{"suspended_at": now, "suspended_reason": req.Reason}
+	if err := updateUserWithAudit(c, user, updates, "suspended", "", req.Reason); err != nil {
+		log.Error("停用账号失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "停用账号失败"})
+		return
+	}
+	if err := revokeSessions(database.DB, user.ID, 0, models.SessionRevokedSuspended); err != nil {
+		log.Error("注销会话失败: %v", err)
+	}
+	log.Info("用户[%d]停用了用户[%d]", c.GetUint("userID"), user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "账号已停用", "user": user})
+}
+
+// UnsuspendUser 恢复被停用的账号，用户需要重新登录
+func UnsuspendUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if user.SuspendedAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "账号未被停用"})
+		return
+	}
+	updates := map[string]any{"suspended_at": nil, "suspended_reason": ""}
+	if err := updateUserWithAudit(c, user, updates, "suspended", user.SuspendedReason, ""); err != nil {
+		log.Error("恢复账号失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复账号失败"})
+		return
+	}
+	log.Info("用户[%d]恢复了用户[%d]", c.GetUint("userID"), user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "账号已恢复", "user": user})
+}
+
+type SetRoleRequest struct {
+	Role models.Role `json:"role" binding:"required"`
+}
+
+// SetUserRole 修改用户角色，立即生效
+func SetUserRole(c *gin.Context) {
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未知的角色"})
+		return
+	}
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if user.Role == req.Role {
+		c.JSON(http.StatusOK, gin.H{"message": "角色未变化", "user": user})
+		return
+	}
+	oldRole := user.Role
+	if err := updateUserWithAudit(c, user, map[string]any{"role": req.Role}, "role", string(oldRole), string(req.Role)); err != nil {
+		log.Error("修改角色失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改角色失败"})
+		return
+	}
+	log.Info("用户[%d]把用户[%d]的角色从%s改为%s", c.GetUint("userID"), user.ID, oldRole, req.Role)
+	c.JSON(http.StatusOK, gin.H{"message": "角色已修改", "user": user})
+}
+
+// AdminCardHistory 查看任意卡片的流转记录
+func AdminCardHistory(c *gin.Context) {
+	var card models.Card
+	if err := database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).First(&card, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "卡片不存在"})
+		return
+	}
+	var transactions []models.CardTransaction
+	if err := database.DB.Preload("FromUser", models.IncludeDeleted).Preload("ToUser", models.IncludeDeleted).
+		Where("card_id = ?", card.ID).
+		Order("id").
+		Find(&transactions).Error; err != nil {
+		log.Error("获取卡片记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取卡片记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"card": card, "transactions": transactions})
+}
+
+// AdminStats 系统统计
+func AdminStats(c *gin.Context) {
+	type keyCount struct {
+		Key   string `json:"key"`
+		Count int64  `json:"count"`
+	}
+	now := time.Now()
+
+	var users, suspended, newUsers int64
+	roles := []keyCount{}
+	database.DB.Model(&models.User{}).Count(&users)
+	database.DB.Model(&models.User{}).Where("suspended_at IS NOT NULL").Count(&suspended)
+	database.DB.Model(&models.User{}).Where("created_at > ?", now.AddDate(0, 0, -7)).Count(&newUsers)
+	database.DB.Model(&models.User{}).Select("role as key, count(*) as count").Group("role").Scan(&roles)
+
+	cards := []keyCount{}
+	database.DB.Model(&models.Card{}).Select("status as key, count(*) as count").Group("status").Scan(&cards)
+	var transactions int64
+	database.DB.Model(&models.CardTransaction{}).Where("created_at > ?", now.Add(-24*time.Hour)).Count(&transactions)
+
+	var sessions int64
+	database.DB.Model(&models.Session{}).Where("revoked_at IS NULL AND expires_at > ?", now).Count(&sessions)
+	outbox := []keyCount{}
+	database.DB.Model(&models.OutboxMessage{}).Select("status as key, count(*) as count").Group("status").Scan(&outbox)
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": gin.H{
+			"total":     users,
+			"suspended": suspended,
+			"new_7d":    newUsers,
+			"by_role":   roles,
+		},
+		"cards": gin.H{
+			"by_status":        cards,
+			"transactions_24h": transactions,
+		},
+		"active_sessions": sessions,
+		"outbox":          outbox,
+	})
+}
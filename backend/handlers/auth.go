@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"card-authorization/config"
 	"card-authorization/database"
 	"card-authorization/log"
 	"card-authorization/middleware"
 	"card-authorization/models"
+	"card-authorization/notify"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,6 +20,7 @@ type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=6"`
 	Nickname string `json:"nickname"`
+	Locale   string `json:"locale"` // 邮件语言，不填时按 Accept-Language 选择
 }
 
 type LoginRequest struct {
@@ -24,7 +29,7 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token   string       `json:"token"`
+	TokenResponse
 	User    *models.User `json:"user"`
 	Message string       `json:"message"`
 }
@@ -49,12 +54,21 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// 创建新用户
+	locale := req.Locale
+	if locale == "" {
+		locale = c.GetHeader("Accept-Language")
+	}
+
+	// 创建新用户，先记下验证邮件的发送时间，补全老用户验证状态时据此区分
+	now := time.Now()
 	user := &models.User{
 		Username: req.Username,
 		Email:    req.Email,
 		Password: req.Password,
 		Nickname: req.Nickname,
+		Locale:   notify.LocaleFromAcceptLanguage(locale),
+
+		VerificationSentAt: &now,
 	}
 
 	if err := database.DB.Create(user).Error; err != nil {
@@ -62,17 +76,24 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// 生成JWT token
-	token, err := middleware.GenerateToken(user.ID)
+	// 创建会话
+	tokens, err := startSession(c, user.ID)
 	if err != nil {
+		log.Error("创建会话失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成token失败"})
 		return
 	}
 
+	message := "注册成功，请查收验证邮件"
+	if err := sendVerificationEmail(user); err != nil {
+		log.Error("发送验证邮件失败: %v", err)
+		message = "注册成功，验证邮件发送失败，请稍后重新发送"
+	}
+
 	c.JSON(http.StatusCreated, AuthResponse{
-		Token:   token,
-		User:    user,
-		Message: "注册成功",
+		TokenResponse: *tokens,
+		User:          user,
+		Message:       message,
 	})
 }
 
@@ -83,33 +104,85 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	if config.SystemConfig.DisablePasswordLogin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "已关闭密码登录，请使用邮件链接登录"})
+		return
+	}
+
+	// 失败次数过多时直接拒绝，不再校验密码
+	defer lockLoginAttempt(req.Username, c.ClientIP())()
+	if rejectThrottledLogin(c, req.Username) {
+		return
+	}
+
 	// 查找用户
 	var user models.User
 	if err := database.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
+		loginFailed(c, req.Username, nil, "unknown_user")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 		return
 	}
 
 	// 验证密码
 	if !user.CheckPassword(req.Password) {
+		loginFailed(c, req.Username, &user, "password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 		return
 	}
 
-	// 生成JWT token
-	token, err := middleware.GenerateToken(user.ID)
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": user.SuspensionMessage()})
+		return
+	}
+
+	// 开启了两步验证时先返回临时token，验证码通过后再创建会话
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusOK, MFARequiredResponse{
+			MFARequired: true,
+			MFAToken:    signMFAToken(user.ID),
+			Message:     "请输入两步验证码",
+		})
+		return
+	}
+
+	completeLogin(c, &user, "")
+}
+
+// completeLogin 身份验证通过后创建会话并返回token
+func completeLogin(c *gin.Context, user *models.User, method string) {
+	tokens, err := loginSession(c, user, method)
+	if errors.Is(err, errAccountSuspended) {
+		c.JSON(http.StatusForbidden, gin.H{"error": user.SuspensionMessage()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成token失败"})
 		return
 	}
 
 	c.JSON(http.StatusOK, AuthResponse{
-		Token:   token,
-		User:    &user,
-		Message: "登录成功",
+		TokenResponse: *tokens,
+		User:          user,
+		Message:       "登录成功",
 	})
 }
 
+var errAccountSuspended = errors.New("账号已被停用")
+
+// loginSession 记录登录成功并创建会话，method 为密码以外的登录方式
+func loginSession(c *gin.Context, user *models.User, method string) (*TokenResponse, error) {
+	if user.SuspendedAt != nil {
+		return nil, errAccountSuspended
+	}
+	recordSecurityEvent(c, models.SecurityLoginSuccess, user.Username, user, method)
+	notifyNewDevice(c, user)
+	tokens, err := startSession(c, user.ID)
+	if err != nil {
+		log.Error("创建会话失败: %v", err)
+	}
+	return tokens, err
+}
+
 func GetProfile(c *gin.Context) {
 	userID := c.GetUint("userID")
 
@@ -122,99 +195,24 @@ func GetProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-func ListUsers(c *gin.Context) {
-	var users []models.User
-	if err := database.DB.Table("users").Order("created_at desc").Find(&users).Error; err != nil {
-		log.Error("获取用户失败", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "获取用户失败"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"users": users})
-}
+// UpdateUser 修改自己的资料，路径中的ID必须是当前登录用户
+// 管理员修改其他用户请使用 /api/admin/users/:id/update
 func UpdateUser(c *gin.Context) {
-	// 定义接收前端数据的结构体
-	type Request struct {
-		User models.User `json:"user"`
-	}
-
-	var req Request
-	// 绑定并验证前端传递的JSON数据
-	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Error("数据绑定失败", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
-		return
-	}
-
-	// 检查用户ID是否存在
-	if req.User.ID == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID不能为空"})
+	userID := c.GetUint("userID")
+	if c.Param("id") != strconv.FormatUint(uint64(userID), 10) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己的资料"})
 		return
 	}
-
-	// 执行更新操作
-	if err := database.DB.Model(&models.User{}).Where("id = ?", req.User.ID).Updates(&req.User).Error; err != nil {
-		log.Error("更新用户失败", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新用户失败"})
+	var req ProfileUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// 返回更新成功的响应
-	c.JSON(http.StatusOK, gin.H{
-		"message": "用户信息更新成功",
-		"user":    req.User,
-	})
-}
-
-// LastActive 我最近的活动
-func LastActive(c *gin.Context) {
-	userID := c.GetUint("userID")
-	var cards []models.Card
-	if err := database.DB.Table("cards").
-		Preload("Creator").
-		Preload("Owner").
-		Joins("INNER JOIN card_transactions ct ON ct.card_id = cards.id").
-		Where("cards.status != ?", "expired").
-		Where("ct.from_user_id = ? OR ct.to_user_id = ?", userID, userID).
-		Select("cards.*, ct.created_at as transaction_at, ct.type as transaction_type").
-		Order("ct.created_at DESC").
-		Limit(5).
-		Find(&cards).Error; err != nil {
-		log.Error("数据查询异常: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据查询异常"})
-		return
-	}
-
-	//格式化数据
-	type Active struct {
-		CreatorNickname string    `json:"creator_nickname"`
-		OwnerNickname   string    `json:"owner_nickname"`
-		CreatorID       uint      `json:"creator_id"`
-		CardTitle       string    `json:"card_title"`
-		CardDescription string    `json:"card_description"`
-		TransactionAt   time.Time `json:"transaction_at"`
-		TransactionType string    `json:"transaction_type"`
-	}
-
-	activeCards := make([]Active, 0, 5)
-	for i := range cards {
-		activeCards = append(activeCards, Active{
-			CreatorNickname: cards[i].Creator.Nickname,
-			OwnerNickname:   cards[i].Owner.Nickname,
-			CreatorID:       cards[i].CreatorID,
-			CardTitle:       cards[i].Title,
-			CardDescription: cards[i].Description,
-			TransactionAt:   *cards[i].TransactionAt,
-			TransactionType: cards[i].TransactionType,
-		})
-	}
-
-	// 返回更新成功的响应
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "最近活动信息",
-		"activeCards": activeCards,
-	})
+	updateProfile(c, userID, userID, &req)
 }
 
-func Test(c *gin.Context) {
-	LastActive(c)
+// JWKS 公开RS256/EdDSA签名密钥的公钥
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": middleware.PublicJWKs()})
 }
@@ -4,7 +4,7 @@ import (
 	"card-authorization/database"
 	"card-authorization/log"
 	"card-authorization/models"
-	"card-authorization/utils"
+	"card-authorization/notify"
 	"net/http"
 	"time"
 
@@ -44,7 +44,8 @@ func CreateCard(c *gin.Context) {
 	}
 
 	// 预加载关联数据
-	database.DB.Preload("Creator").First(card, card.ID)
+	database.DB.Preload("Creator", models.IncludeDeleted).First(card, card.ID)
+	recordCardActivity(models.ActivityCardCreate, userID, card, &card.Creator, &card.Creator)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "卡片创建成功",
@@ -56,7 +57,7 @@ func GetMyCards(c *gin.Context) {
 	userID := c.GetUint("userID")
 
 	var cards []models.Card
-	if err := database.DB.Preload("Creator").Preload("Owner").
+	if err := database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).
 		Where("creator_id = ? and owner_id = ?", userID, userID).
 		Order("status,updated_at DESC").
 		Find(&cards).Error; err != nil {
@@ -71,7 +72,7 @@ func GetSendCards(c *gin.Context) {
 	userID := c.GetUint("userID")
 
 	var cards []models.Card
-	if err := database.DB.Preload("Creator").Preload("Owner").
+	if err := database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).
 		Where("owner_id != ? AND creator_id = ?", userID, userID).
 		Order("status,updated_at DESC").
 		Find(&cards).Error; err != nil {
@@ -85,7 +86,7 @@ func GetReceivedCards(c *gin.Context) {
 	userID := c.GetUint("userID")
 
 	var cards []models.Card
-	if err := database.DB.Preload("Creator").Preload("Owner").
+	if err := database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).
 		Where("owner_id = ? AND creator_id != ? and status = ?", userID, userID, "active").
 		Order("updated_at DESC").
 		Find(&cards).Error; err != nil {
@@ -100,7 +101,7 @@ func UseCard(c *gin.Context) {
 	cardID := c.Param("id")
 
 	var card models.Card
-	if err := database.DB.Preload("Creator").Preload("Owner").First(&card, cardID).Error; err != nil {
+	if err := database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).First(&card, cardID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "卡片不存在"})
 		return
 	}
@@ -136,12 +137,23 @@ func UseCard(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "记录交易失败"})
 		return
 	}
-	//发送邮件通知卡片创造者，拥有者已经使用当前卡片
-	if card.Creator.Email != "" {
-		var body = buildEmailBodyOfUse(card.Owner.Nickname, card.Title)
-		if err := utils.SendEmail(card.Creator.Email, card.Title, body); err != nil {
-			log.Error("向%s发送邮件失败", card.Creator.Nickname)
-		}
+	recordCardActivity(models.ActivityCardUse, userID, &card, &card.Owner, &card.Creator)
+	//通知卡片创造者，拥有者已经使用当前卡片
+	if card.CreatorID != userID {
+		notify.Send(&models.Notification{
+			UserID:       card.CreatorID,
+			Type:         models.ActivityCardUse,
+			Title:        "用卡通知",
+			Content:      models.SenderPlaceholder + " 使用了你的卡：" + models.EscapeNotificationText(card.Title),
+			FromUserID:   userID,
+			FromNickname: card.Owner.Nickname,
+			CardID:       &card.ID,
+		}, &notify.Email{
+			To:       card.Creator.Email,
+			Locale:   card.Creator.Locale,
+			Template: "card_use",
+			Data:     map[string]any{"FromNickname": card.Owner.Nickname, "CardTitle": card.Title},
+		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -154,7 +166,7 @@ func UsedCard(c *gin.Context) {
 	userID := c.GetUint("userID")
 
 	var cards []models.Card
-	if err := database.DB.Preload("Creator").Preload("Owner").
+	if err := database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).
 		Where("creator_id=? or owner_id=?", userID, userID).
 		Where("status=?", "used").
 		Order("updated_at desc").
@@ -179,7 +191,7 @@ func SendCard(c *gin.Context) {
 	}
 
 	var card models.Card
-	if err := database.DB.Preload("Owner").First(&card, cardID).Error; err != nil {
+	if err := database.DB.Preload("Owner", models.IncludeDeleted).First(&card, cardID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "卡片不存在"})
 		return
 	}
@@ -224,29 +236,34 @@ func SendCard(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "记录交易失败"})
 		return
 	}
-	// 如果接收者有邮箱则发送邮件
-	if toUser.Email != "" {
-		var body = buildEmailBodyOfSend(oldOwner.Nickname, card.Title)
-		if err := utils.SendEmail(toUser.Email, card.Title, body); err != nil {
-			log.Error("向%s发送邮件失败", toUser.Nickname)
-			c.JSON(http.StatusOK, gin.H{
-				"message": "卡片发送成功，邮件通知失败！",
-				"card":    card,
-			})
-			return
-		} else {
-			c.JSON(http.StatusOK, gin.H{
-				"message": "卡片发送成功，已邮件通知！",
-				"card":    card,
-			})
-			return
-		}
-	} else {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "卡片发送成功",
-			"card":    card,
-		})
+	recordCardActivity(models.ActivityCardSend, userID, &card, &oldOwner, &toUser)
+	// 通知接收者，有邮箱时同时发送邮件
+	notification := &models.Notification{
+		UserID:       toUser.ID,
+		Type:         models.ActivityCardSend,
+		Title:        "新卡片通知",
+		Content:      "你收到了来自 " + models.SenderPlaceholder + " 的卡：" + models.EscapeNotificationText(card.Title),
+		FromUserID:   userID,
+		FromNickname: oldOwner.Nickname,
+		CardID:       &card.ID,
+	}
+	notify.Send(notification, &notify.Email{
+		To:       toUser.Email,
+		Locale:   toUser.Locale,
+		Template: "card_send",
+		Data:     map[string]any{"FromNickname": oldOwner.Nickname, "CardTitle": card.Title},
+	})
+	message := "卡片发送成功"
+	switch notification.EmailStatus {
+	case models.EmailStatusPending:
+		message = "卡片发送成功，将邮件通知！"
+	case models.EmailStatusFailed:
+		message = "卡片发送成功，邮件通知失败！"
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"card":    card,
+	})
 }
 
 func DeleteCard(c *gin.Context) {
@@ -276,6 +293,70 @@ func DeleteCard(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "卡片删除成功"})
 }
 
+// RevokeCard 收回已送出但尚未使用的卡，卡片回到创造者手中，可以重新送出
+func RevokeCard(c *gin.Context) {
+	userID := c.GetUint("userID")
+	cardID := c.Param("id")
+
+	var card models.Card
+	if err := database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).First(&card, cardID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "卡片不存在"})
+		return
+	}
+	// 只有创造者可以收回
+	if card.CreatorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权收回该卡片"})
+		return
+	}
+	if card.OwnerID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "卡片还未送出"})
+		return
+	}
+	if card.Status != models.CardStatusActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "卡片已使用或已过期"})
+		return
+	}
+
+	// 不用 Save，避免连带写回预加载的 Creator 和 Owner
+	holder := card.Owner
+	now := time.Now()
+	if err := database.DB.Model(&models.Card{}).Where("id = ?", card.ID).
+		UpdateColumns(map[string]any{"owner_id": card.CreatorID, "updated_at": now}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "收回卡片失败"})
+		return
+	}
+	card.OwnerID = card.CreatorID
+	card.Owner = card.Creator
+	card.UpdatedAt = now
+
+	// 记录交易
+	transaction := &models.CardTransaction{
+		CardID:     card.ID,
+		FromUserID: userID,
+		ToUserID:   holder.ID,
+		Type:       "revoke",
+	}
+	if err := database.DB.Create(transaction).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "记录交易失败"})
+		return
+	}
+	recordCardActivity(models.ActivityCardRevoke, userID, &card, &card.Creator, &holder)
+	notify.Send(&models.Notification{
+		UserID:       holder.ID,
+		Type:         models.ActivityCardRevoke,
+		Title:        "卡片收回通知",
+		Content:      models.SenderPlaceholder + " 收回了送给你的卡：" + models.EscapeNotificationText(card.Title),
+		FromUserID:   userID,
+		FromNickname: card.Creator.Nickname,
+		CardID:       &card.ID,
+	}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "卡片已收回",
+		"card":    card,
+	})
+}
+
 // CopyCard 复制卡
 func CopyCard(c *gin.Context) {
 	userID := c.GetUint("userID")
@@ -298,249 +379,65 @@ func CopyCard(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建卡片失败"})
 		return
 	}
+	database.DB.Preload("Creator", models.IncludeDeleted).First(cardNew, cardNew.ID)
+	recordCardActivity(models.ActivityCardCreate, userID, cardNew, &cardNew.Creator, &cardNew.Creator)
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "卡片创建成功",
 	})
 }
 
-// 生成美化的邮件内容(发送卡)
-func buildEmailBodyOfSend(formNickname, cardTitle string) string {
-	body := `
-        <!DOCTYPE html>
-            <html>
-            <head>
-                <meta charset="UTF-8">
-                <title>新卡片通知</title>
-                <style>
-                    body {
-                        font-family: 'Helvetica Neue', Arial, sans-serif;
-                        background-color: #f9f9f9;
-                        margin: 0;
-                        padding: 20px;
-                        color: #333;
-                    }
-                    .container {
-                        max-width: 600px;
-                        margin: 0 auto;
-                        background-color: white;
-                        border-radius: 12px;
-                        box-shadow: 0 4px 12px rgba(0,0,0,0.1);
-                        overflow: hidden;
-                    }
-                    .header {
-                        background: linear-gradient(135deg, #4a90e2, #5c6bc0);
-                        color: white;
-                        padding: 25px 30px;
-                        text-align: center;
-                    }
-                    .header h1 {
-                        margin: 0;
-                        font-size: 24px;
-                        font-weight: 600;
-                    }
-                    .content {
-                        padding: 30px;
-                        text-align: center;
-                    }
-                    .greeting {
-                        font-size: 18px;
-                        margin-bottom: 25px;
-                        color: #555;
-                    }
-                    .card-notification {
-                        background-color: #fff8e1;
-                        border-left: 5px solid #ffc107;
-                        padding: 20px;
-                        border-radius: 8px;
-                        margin: 20px 0;
-                        font-size: 16px;
-                        line-height: 1.6;
-                    }
-                    .highlight {
-                        color: #e91e63;
-                        font-weight: bold;
-                        font-size: 18px;
-                    }
-                    .app-link {
-                        margin: 30px 0;
-                        padding: 20px;
-                        background-color: #e3f2fd;
-                        border-radius: 8px;
-                    }
-                    .app-link a {
-                        color: #1976d2;
-                        font-size: 18px;
-                        font-weight: bold;
-                        text-decoration: none;
-                        border-bottom: 2px solid #1976d2;
-                        padding-bottom: 3px;
-                    }
-                    .app-link a:hover {
-                        color: #0d47a1;
-                        border-bottom-color: #0d47a1;
-                    }
-                    .footer {
-                        background-color: #f5f5f5;
-                        padding: 20px 30px;
-                        text-align: center;
-                        color: #777;
-                        font-size: 14px;
-                    }
-                </style>
-            </head>
-            <body>
-                <div class="container">
-                    <div class="header">
-                        <h1>🎉 新卡片通知</h1>
-                    </div>
-                    <div class="content">
-                        <p class="greeting">恭喜你！</p>
-                        <div class="card-notification">
-                            你收到了来自 <span class="highlight">` + formNickname + `</span> 的卡：
-                            <br><br>
-                            <span class="highlight">` + cardTitle + `</span>
-                        </div>
-                        <div class="app-link">
-                            点击访问应用查看详情：<br><br>
-                            <a href="http://wangxiang-pro.top:18080/" target="_blank">点我查看吆🎀</a>
-                        </div>
-                        <p>快去体验专为情侣和朋友设计的互动卡片系统吧～</p>
-                    </div>
-                    <div class="footer">
-                        这是一封自动发送的通知邮件，无需回复
-                    </div>
-                </div>
-            </body>
-        </html>
-    `
-	return body
-}
+// 卡片过期前多久提醒拥有者
+const expiryReminderWindow = 24 * time.Hour
 
-// 生成美化的邮件内容（使用卡）
-func buildEmailBodyOfUse(formNickname, cardTitle string) string {
-	body := `
-        <!DOCTYPE html>
-            <html>
-            <head>
-                <meta charset="UTF-8">
-                <title>用卡通知</title>
-                <style>
-                    body {
-                        font-family: 'Helvetica Neue', Arial, sans-serif;
-                        background-color: #f9f9f9;
-                        margin: 0;
-                        padding: 20px;
-                        color: #333;
-                    }
-                    .container {
-                        max-width: 600px;
-                        margin: 0 auto;
-                        background-color: white;
-                        border-radius: 12px;
-                        box-shadow: 0 4px 12px rgba(0,0,0,0.1);
-                        overflow: hidden;
-                    }
-                    .header {
-                        background: linear-gradient(135deg, #4a90e2, #5c6bc0);
-                        color: white;
-                        padding: 25px 30px;
-                        text-align: center;
-                    }
-                    .header h1 {
-                        margin: 0;
-                        font-size: 24px;
-                        font-weight: 600;
-                    }
-                    .content {
-                        padding: 30px;
-                        text-align: center;
-                    }
-                    .greeting {
-                        font-size: 18px;
-                        margin-bottom: 25px;
-                        color: #555;
-                    }
-                    .card-notification {
-                        background-color: #fff8e1;
-                        border-left: 5px solid #ffc107;
-                        padding: 20px;
-                        border-radius: 8px;
-                        margin: 20px 0;
-                        font-size: 16px;
-                        line-height: 1.6;
-                    }
-                    .highlight {
-                        color: #e91e63;
-                        font-weight: bold;
-                        font-size: 18px;
-                    }
-                    .app-link {
-                        margin: 30px 0;
-                        padding: 20px;
-                        background-color: #e3f2fd;
-                        border-radius: 8px;
-                    }
-                    .app-link a {
-                        color: #1976d2;
-                        font-size: 18px;
-                        font-weight: bold;
-                        text-decoration: none;
-                        border-bottom: 2px solid #1976d2;
-                        padding-bottom: 3px;
-                    }
-                    .app-link a:hover {
-                        color: #0d47a1;
-                        border-bottom-color: #0d47a1;
-                    }
-                    .footer {
-                        background-color: #f5f5f5;
-                        padding: 20px 30px;
-                        text-align: center;
-                        color: #777;
-                        font-size: 14px;
-                    }
-                </style>
-            </head>
-            <body>
-                <div class="container">
-                    <div class="header">
-                        <h1>🎉 用卡通知</h1>
-                    </div>
-                    <div class="content">
-                        <p class="greeting">你好！</p>
-                        <div class="card-notification">
-							<span class="highlight">` + formNickname + `</span>使用了来自你的卡：
-                            <br><br>
-                            <span class="highlight">` + cardTitle + `</span>
-                        </div>
-                        <div class="app-link">
-                            点击访问应用查看详情：<br><br>
-                            <a href="http://wangxiang-pro.top:18080/" target="_blank">点我查看吆🎀</a>
-                        </div>
-                        <p>快去体验专为情侣和朋友设计的互动卡片系统吧～</p>
-                    </div>
-                    <div class="footer">
-                        这是一封自动发送的通知邮件，无需回复
-                    </div>
-                </div>
-            </body>
-        </html>
-    `
-	return body
-}
-
-// CheckExpiredCards 定时确认card过期状态
+// CheckExpiredCards 定时确认card过期状态，并提醒即将过期的卡
 func CheckExpiredCards() {
 	// 启动时立即运行一次
 	processExpiredCards()
+	processExpiringCards()
 
-	// 每5小时运行一次
-	ticker := time.NewTicker(5 * time.Hour)
+	// 每小时运行一次
+	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
 
 	for {
 		<-ticker.C
 		processExpiredCards()
+		processExpiringCards()
+	}
+}
+
+// 提醒拥有者卡片即将过期，每张卡只提醒一次
+func processExpiringCards() {
+	var cards []models.Card
+	now := time.Now()
+	database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).
+		Where("expires_at >= ? AND expires_at < ? AND status = ? AND reminded_at IS NULL", now, now.Add(expiryReminderWindow), "active").
+		Find(&cards)
+	for _, card := range cards {
+		database.DB.Model(&card).UpdateColumn("reminded_at", now)
+		// 按拥有者的时区显示过期时间
+		setting := notify.SettingFor(card.OwnerID)
+		loc, err := time.LoadLocation(setting.Timezone)
+		if err != nil {
+			loc = time.Local
+		}
+		notify.Send(&models.Notification{
+			UserID:     card.OwnerID,
+			Type:       models.NotificationCardExpiring,
+			Title:      "卡片即将过期",
+			Content:    "你的卡即将过期：" + models.EscapeNotificationText(card.Title),
+			FromUserID: card.CreatorID,
+			CardID:     &card.ID,
+		}, &notify.Email{
+			To:       card.Owner.Email,
+			Locale:   card.Owner.Locale,
+			Template: "card_expiring",
+			Data: map[string]any{
+				"FromNickname": card.Creator.Nickname,
+				"CardTitle":    card.Title,
+				"ExpiresAt":    card.ExpiresAt.In(loc).Format("2006-01-02 15:04"),
+			},
+		})
 	}
 }
 
@@ -549,10 +446,18 @@ func processExpiredCards() {
 	var cards []models.Card
 	now := time.Now()
 	// 查询并更新所有符合条件的过期卡片
-	database.DB.Where("expires_at < ? AND status = ?", now, "active").Find(&cards)
+	database.DB.Preload("Creator", models.IncludeDeleted).Preload("Owner", models.IncludeDeleted).Where("expires_at < ? AND status = ?", now, "active").Find(&cards)
 	for _, card := range cards {
 		card.Status = "expired"
 		card.UpdatedAt = time.Now()
 		database.DB.Save(&card)
+		recordCardActivity(models.ActivityCardExpire, 0, &card, &card.Owner, &card.Creator)
+		notify.Send(&models.Notification{
+			UserID:  card.OwnerID,
+			Type:    models.ActivityCardExpire,
+			Title:   "卡片过期通知",
+			Content: "你的卡已过期：" + models.EscapeNotificationText(card.Title),
+			CardID:  &card.ID,
+		}, nil)
 	}
 }
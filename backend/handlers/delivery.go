@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListEmailDeliveries 邮件投递记录：发给我的邮件，以及我创建或持有的卡片相关的邮件
+// 支持 card_id、status 过滤和 page/page_size 分页
+func ListEmailDeliveries(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	myCards := database.DB.Model(&models.Card{}).Select("id").Where("creator_id = ? OR owner_id = ?", userID, userID)
+	query := database.DB.Model(&models.EmailDelivery{}).Where("user_id = ? OR card_id IN (?)", userID, myCards)
+	if cardID := c.Query("card_id"); cardID != "" {
+		id, err := strconv.ParseUint(cardID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "卡片ID无效"})
+			return
+		}
+		query = query.Where("card_id = ?", id)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Error("获取投递记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取投递记录失败"})
+		return
+	}
+	var deliveries []models.EmailDelivery
+	if err := query.Order("id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&deliveries).Error; err != nil {
+		log.Error("获取投递记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取投递记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"total":      total,
+		"page":       page,
+		"page_size":  pageSize,
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"time"
+)
+
+// 检查摘要是否到期的间隔
+const digestCheckInterval = 10 * time.Minute
+
+// SendDigests 定时给开启摘要模式的用户发送每日/每周摘要邮件
+func SendDigests() {
+	processDigests()
+
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		processDigests()
+	}
+}
+
+func processDigests() {
+	var settings []models.NotificationSetting
+	if err := database.DB.Where("digest_mode IN ?", []string{models.DigestModeDaily, models.DigestModeWeekly}).
+		Find(&settings).Error; err != nil {
+		log.Error("查询摘要设置失败: %v", err)
+		return
+	}
+	now := time.Now()
+	for i := range settings {
+		setting := &settings[i]
+		dueAt := notify.DigestDueAt(setting, now)
+		if dueAt.IsZero() || (setting.LastDigestAt != nil && !setting.LastDigestAt.Before(dueAt)) {
+			continue
+		}
+		if err := sendDigest(setting, now); err != nil {
+			log.Error("用户[%d]摘要发送失败: %v", setting.UserID, err)
+			continue
+		}
+		notify.MarkDigestSent(setting.UserID, now)
+	}
+}
+
+// sendDigest 汇总上次摘要以来收到的卡、被使用的卡，以及待处理的邀请和即将过期的卡
+// 用户关闭了某类事件的邮件时，摘要中也不包含该类事件
+func sendDigest(setting *models.NotificationSetting, now time.Time) error {
+	var user models.User
+	if err := database.DB.First(&user, setting.UserID).Error; err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		return nil
+	}
+	period := notify.DigestPeriod(setting.DigestMode)
+	since := now.Add(-period)
+	if setting.LastDigestAt != nil {
+		since = *setting.LastDigestAt
+	}
+	loc, err := time.LoadLocation(setting.Timezone)
+	if err != nil {
+		loc = time.Local
+	}
+	format := func(t time.Time) string { return t.In(loc).Format("01-02 15:04") }
+
+	emailOn := func(event models.ActivityType) bool {
+		return notify.PreferenceFor(user.ID, event).Email
+	}
+
+	var received, used, invites, expiring []notify.DigestItem
+	if emailOn(models.ActivityCardSend) {
+		received = digestTransactions(user.ID, "send", since, now, format)
+	}
+	if emailOn(models.ActivityCardUse) {
+		used = digestTransactions(user.ID, "use", since, now, format)
+	}
+	if emailOn(models.ActivityFriendInvite) {
+		var pending []models.FriendInvite
+		database.DB.Where("to_user_id = ? AND status = ?", user.ID, "pending").Order("id ASC").Find(&pending)
+		for _, invite := range pending {
+			var from models.User
+			if err := database.DB.First(&from, invite.FromUserID).Error; err != nil {
+				continue
+			}
+			invites = append(invites, notify.DigestItem{Nickname: from.Nickname, Time: format(invite.CreatedAt)})
+		}
+	}
+	if emailOn(models.NotificationCardExpiring) {
+		var cards []models.Card
+		database.DB.Preload("Creator", models.IncludeDeleted).
+			Where("owner_id = ? AND status = ? AND expires_at >= ? AND expires_at < ?", user.ID, models.CardStatusActive, now, now.Add(period)).
+			Order("expires_at ASC").
+			Find(&cards)
+		for _, card := range cards {
+			expiring = append(expiring, notify.DigestItem{Nickname: card.Creator.Nickname, CardTitle: card.Title, Time: format(*card.ExpiresAt)})
+		}
+	}
+
+	// 没有任何内容时不打扰用户
+	if len(received)+len(used)+len(invites)+len(expiring) == 0 {
+		return nil
+	}
+	return notify.SendDigest(&user, setting, map[string]any{
+		"Received": received,
+		"Used":     used,
+		"Invites":  invites,
+		"Expiring": expiring,
+	})
+}
+
+// digestTransactions 统计区间内发给用户的某类卡片交易，use 类型的接收人是卡的创建者
+func digestTransactions(userID uint, txType string, since, until time.Time, format func(time.Time) string) []notify.DigestItem {
+	var transactions []models.CardTransaction
+	database.DB.Preload("Card").Preload("FromUser", models.IncludeDeleted).
+		Where("to_user_id = ? AND type = ? AND created_at > ? AND created_at <= ?", userID, txType, since, until).
+		Order("id ASC").
+		Find(&transactions)
+	items := make([]notify.DigestItem, 0, len(transactions))
+	for _, tx := range transactions {
+		items = append(items, notify.DigestItem{Nickname: tx.FromUser.Nickname, CardTitle: tx.Card.Title, Time: format(tx.CreatedAt)})
+	}
+	return items
+}
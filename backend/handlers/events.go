@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 心跳间隔，避免代理因为空闲断开连接
+	eventHeartbeatInterval = 25 * time.Second
+	// 重连时最多补发的事件数，更早的由客户端通过动态接口拉取
+	eventReplayLimit = 200
+)
+
+// EventStream 通过SSE实时推送卡片和道友事件
+// 事件ID即动态ID，客户端重连时带上 Last-Event-ID（或 last_event_id 参数）即可补发断线期间的事件
+func EventStream(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID无效"})
+			return
+		}
+	}
+
+	// 先订阅再补发，避免补发期间产生的事件丢失
+	sub := notify.DefaultHub.Subscribe(userID)
+	defer notify.DefaultHub.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// 告诉浏览器断线后的重连间隔
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	sentID := uint(lastID)
+	if lastID > 0 {
+		var activities []models.Activity
+		if err := database.DB.Where("user_id = ? AND id > ?", userID, lastID).
+			Order("id ASC").
+			Limit(eventReplayLimit).
+			Find(&activities).Error; err != nil {
+			log.Error("补发事件失败: %v", err)
+		}
+		for i := range activities {
+			if !writeEvent(c, activityEvent(&activities[i])) {
+				return
+			}
+			sentID = activities[i].ID
+		}
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case event, ok := <-sub.C:
+			if !ok {
+				// 服务关闭或消费过慢被断开
+				return
+			}
+			// 补发时已经发过的跳过
+			if event.ID <= sentID {
+				continue
+			}
+			if !writeEvent(c, event) {
+				return
+			}
+			sentID = event.ID
+		}
+	}
+}
+
+func writeEvent(c *gin.Context, event notify.Event) bool {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Error("序列化事件失败: %v", err)
+		return true
+	}
+	if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+		return false
+	}
+	c.Writer.Flush()
+	return true
+}
+
+func activityEvent(activity *models.Activity) notify.Event {
+	return notify.Event{
+		ID:   activity.ID,
+		Type: string(activity.Type),
+		Data: activity,
+	}
+}
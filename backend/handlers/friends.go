@@ -4,7 +4,7 @@ import (
 	"card-authorization/database"
 	"card-authorization/log"
 	"card-authorization/models"
-	"card-authorization/utils"
+	"card-authorization/notify"
 	"net/http"
 	"strings"
 	"time"
@@ -25,7 +25,7 @@ func ListFriends(c *gin.Context) {
 		Select("id, username, nickname, email").
 		Where("id IN (?)", subQuery).
 		Find(&users).Error; err != nil {
-		log.Error("获取道友失败", err)
+		log.Error("获取道友失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取道友失败"})
 		return
 	}
@@ -45,7 +45,7 @@ func ListMyInviteFriends(c *gin.Context) {
 		Select("id, username, nickname, email").
 		Where("id IN (?)", subQuery).
 		Find(&users).Error; err != nil {
-		log.Error("获取好友邀请失败", err)
+		log.Error("获取好友邀请失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取好友邀请失败"})
 		return
 	}
@@ -102,7 +102,7 @@ func ListInviteMyFriends(c *gin.Context) {
 		Select("id, username, nickname, email").
 		Where("id IN (?)", subQuery).
 		Find(&users).Error; err != nil {
-		log.Error("获取好友邀请失败", err)
+		log.Error("获取好友邀请失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取好友邀请失败"})
 		return
 	}
@@ -159,7 +159,7 @@ func ListFriendUsers(c *gin.Context) {
 		Select("id, username, nickname, email").
 		Where("id IN (?)", subQuery).
 		Find(&users).Error; err != nil {
-		log.Error("获取好友失败", err)
+		log.Error("获取好友失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取好友失败"})
 		return
 	}
@@ -261,20 +261,32 @@ func InviteFriend(c *gin.Context) {
 		Status:     "pending", // 初始状态为等待
 	}
 	if err := database.DB.Create(&invite).Error; err != nil {
-		log.Error("创建好友邀请失败", err)
+		log.Error("创建好友邀请失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建好友邀请失败"})
 		return
 	}
-	//邮件通知被邀请用户
 	var myUser models.User
 	if err := database.DB.First(&myUser, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "当前用户不存在"})
 		return
 	}
-	subject := "你有一个新的好友邀请"
-	body := buildEmailBodyOfInviteFriend(myUser.Nickname, myUser.Email)
-	if err := utils.SendEmail(invitee.Email, subject, body); err != nil {
-		log.Error("发送好友邀请邮件失败", err)
+	recordFriendActivity(models.ActivityFriendInvite, &myUser, &invitee)
+	//通知被邀请用户
+	notification := &models.Notification{
+		UserID:       invitee.ID,
+		Type:         models.ActivityFriendInvite,
+		Title:        "你有一个新的好友邀请",
+		Content:      models.SenderPlaceholder + " 向你发送了道友申请",
+		FromUserID:   userID,
+		FromNickname: myUser.Nickname,
+	}
+	notify.Send(notification, &notify.Email{
+		To:       invitee.Email,
+		Locale:   invitee.Locale,
+		Template: "friend_invite",
+		Data:     map[string]any{"FromNickname": myUser.Nickname, "FromEmail": myUser.Email},
+	})
+	if notification.EmailStatus == models.EmailStatusFailed {
 		//返回成功响应
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "好友邀请已发送但邮件通知失败"})
 	} else {
@@ -317,7 +329,7 @@ func AcceptFriend(c *gin.Context) {
 	//更新邀请状态为已接受
 	invite.Status = "accepted"
 	if err := database.DB.Save(&invite).Error; err != nil {
-		log.Error("更新好友邀请状态失败", err)
+		log.Error("更新好友邀请状态失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新好友邀请状态失败"})
 		return
 	}
@@ -333,25 +345,37 @@ func AcceptFriend(c *gin.Context) {
 		UpdatedAt: time.Now(),
 	}
 	if err := database.DB.Create(&friend1).Error; err != nil {
-		log.Error("创建好友关系失败", err)
+		log.Error("创建好友关系失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建好友关系失败"})
 		return
 	}
 	if err := database.DB.Create(&friend2).Error; err != nil {
-		log.Error("创建好友关系失败", err)
+		log.Error("创建好友关系失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建好友关系失败"})
 		return
 	}
-	//邮件通知被邀请用户
 	var myUser models.User
 	if err := database.DB.First(&myUser, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "当前用户不存在"})
 		return
 	}
-	subject := myUser.Nickname + " 已接受你的道友邀请"
-	body := buildEmailBodyOfAcceptFriend(myUser.Nickname, myUser.Email)
-	if err := utils.SendEmail(inviter.Email, subject, body); err != nil {
-		log.Error("接受道友邀请邮件发送失败", err)
+	recordFriendActivity(models.ActivityFriendAccept, &myUser, &inviter)
+	//通知邀请人
+	notification := &models.Notification{
+		UserID:       inviter.ID,
+		Type:         models.ActivityFriendAccept,
+		Title:        models.SenderPlaceholder + " 已接受你的道友邀请",
+		Content:      models.SenderPlaceholder + " 同意了你的道友申请",
+		FromUserID:   userID,
+		FromNickname: myUser.Nickname,
+	}
+	notify.Send(notification, &notify.Email{
+		To:       inviter.Email,
+		Locale:   inviter.Locale,
+		Template: "friend_accept",
+		Data:     map[string]any{"FromNickname": myUser.Nickname, "FromEmail": myUser.Email},
+	})
+	if notification.EmailStatus == models.EmailStatusFailed {
 		//返回成功响应
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "接受道友邀请成功，但邮件通知失败"})
 	} else {
@@ -388,18 +412,18 @@ func DeleteFriend(c *gin.Context) {
 	}
 	//删除双向好友关系记录
 	if err := database.DB.Where("user_id = ? AND friend_id = ?", userID, deleteUser.ID).Delete(&models.Friends{}).Error; err != nil {
-		log.Error("删除好友关系失败", err)
+		log.Error("删除好友关系失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除好友关系失败"})
 		return
 	}
 	if err := database.DB.Where("user_id = ? AND friend_id = ?", deleteUser.ID, userID).Delete(&models.Friends{}).Error; err != nil {
-		log.Error("删除好友关系失败", err)
+		log.Error("删除好友关系失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除好友关系失败"})
 		return
 	}
 	//删除邀请记录
 	if err := database.DB.Where("(from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?)", userID, deleteUser.ID, deleteUser.ID, userID).Delete(&models.FriendInvite{}).Error; err != nil {
-		log.Error("删除好友邀请记录失败", err)
+		log.Error("删除好友邀请记录失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除好友邀请记录失败"})
 		return
 	}
@@ -407,229 +431,3 @@ func DeleteFriend(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "好友已删除"})
 
 }
-
-// 生成美化的邮件内容（请求好友）
-func buildEmailBodyOfInviteFriend(fromNickname, fromEmail string) string {
-	body := `
-        <!DOCTYPE html>
-            <html>
-            <head>
-                <meta charset="UTF-8">
-                <title>邀请通知</title>
-                <style>
-                    body {
-                        font-family: 'Helvetica Neue', Arial, sans-serif;
-                        background-color: #f9f9f9;
-                        margin: 0;
-                        padding: 20px;
-                        color: #333;
-                    }
-                    .container {
-                        max-width: 600px;
-                        margin: 0 auto;
-                        background-color: white;
-                        border-radius: 12px;
-                        box-shadow: 0 4px 12px rgba(0,0,0,0.1);
-                        overflow: hidden;
-                    }
-                    .header {
-                        background: linear-gradient(135deg, #4a90e2, #5c6bc0);
-                        color: white;
-                        padding: 25px 30px;
-                        text-align: center;
-                    }
-                    .header h1 {
-                        margin: 0;
-                        font-size: 24px;
-                        font-weight: 600;
-                    }
-                    .content {
-                        padding: 30px;
-                        text-align: center;
-                    }
-                    .greeting {
-                        font-size: 18px;
-                        margin-bottom: 25px;
-                        color: #555;
-                    }
-                    .card-notification {
-                        background-color: #fff8e1;
-                        border-left: 5px solid #ffc107;
-                        padding: 20px;
-                        border-radius: 8px;
-                        margin: 20px 0;
-                        font-size: 16px;
-                        line-height: 1.6;
-                    }
-                    .highlight {
-                        color: #e91e63;
-                        font-weight: bold;
-                        font-size: 18px;
-                    }
-                    .app-link {
-                        margin: 30px 0;
-                        padding: 20px;
-                        background-color: #e3f2fd;
-                        border-radius: 8px;
-                    }
-                    .app-link a {
-                        color: #1976d2;
-                        font-size: 18px;
-                        font-weight: bold;
-                        text-decoration: none;
-                        border-bottom: 2px solid #1976d2;
-                        padding-bottom: 3px;
-                    }
-                    .app-link a:hover {
-                        color: #0d47a1;
-                        border-bottom-color: #0d47a1;
-                    }
-                    .footer {
-                        background-color: #f5f5f5;
-                        padding: 20px 30px;
-                        text-align: center;
-                        color: #777;
-                        font-size: 14px;
-                    }
-                </style>
-            </head>
-            <body>
-                <div class="container">
-                    <div class="header">
-                        <h1>🎉 邀请通知</h1>
-                    </div>
-                    <div class="content">
-                        <p class="greeting">你好！</p>
-                        <div class="card-notification">
-							<span class="highlight">` + fromNickname + `</span>向你发送了道友申请：
-                            <br><br>
-                            <span class="highlight">` + fromEmail + `</span>
-                        </div>
-                        <div class="app-link">
-                            点击访问应用查看详情：<br><br>
-                            <a href="http://wangxiang-pro.top:18080/" target="_blank">点我查看吆🎀</a>
-                        </div>
-                        <p>快去体验专为情侣和朋友设计的互动卡片系统吧～</p>
-                    </div>
-                    <div class="footer">
-                        这是一封自动发送的通知邮件，无需回复
-                    </div>
-                </div>
-            </body>
-        </html>
-    `
-	return body
-}
-
-// 生成美化的邮件内容（接受好友的邀请）
-func buildEmailBodyOfAcceptFriend(fromNickname, fromEmail string) string {
-	body := `
-        <!DOCTYPE html>
-            <html>
-            <head>
-                <meta charset="UTF-8">
-                <title>邀请通过</title>
-                <style>
-                    body {
-                        font-family: 'Helvetica Neue', Arial, sans-serif;
-                        background-color: #f9f9f9;
-                        margin: 0;
-                        padding: 20px;
-                        color: #333;
-                    }
-                    .container {
-                        max-width: 600px;
-                        margin: 0 auto;
-                        background-color: white;
-                        border-radius: 12px;
-                        box-shadow: 0 4px 12px rgba(0,0,0,0.1);
-                        overflow: hidden;
-                    }
-                    .header {
-                        background: linear-gradient(135deg, #4a90e2, #5c6bc0);
-                        color: white;
-                        padding: 25px 30px;
-                        text-align: center;
-                    }
-                    .header h1 {
-                        margin: 0;
-                        font-size: 24px;
-                        font-weight: 600;
-                    }
-                    .content {
-                        padding: 30px;
-                        text-align: center;
-                    }
-                    .greeting {
-                        font-size: 18px;
-                        margin-bottom: 25px;
-                        color: #555;
-                    }
-                    .card-notification {
-                        background-color: #fff8e1;
-                        border-left: 5px solid #ffc107;
-                        padding: 20px;
-                        border-radius: 8px;
-                        margin: 20px 0;
-                        font-size: 16px;
-                        line-height: 1.6;
-                    }
-                    .highlight {
-                        color: #e91e63;
-                        font-weight: bold;
-                        font-size: 18px;
-                    }
-                    .app-link {
-                        margin: 30px 0;
-                        padding: 20px;
-                        background-color: #e3f2fd;
-                        border-radius: 8px;
-                    }
-                    .app-link a {
-                        color: #1976d2;
-                        font-size: 18px;
-                        font-weight: bold;
-                        text-decoration: none;
-                        border-bottom: 2px solid #1976d2;
-                        padding-bottom: 3px;
-                    }
-                    .app-link a:hover {
-                        color: #0d47a1;
-                        border-bottom-color: #0d47a1;
-                    }
-                    .footer {
-                        background-color: #f5f5f5;
-                        padding: 20px 30px;
-                        text-align: center;
-                        color: #777;
-                        font-size: 14px;
-                    }
-                </style>
-            </head>
-            <body>
-                <div class="container">
-                    <div class="header">
-                        <h1>🎉 邀请通过</h1>
-                    </div>
-                    <div class="content">
-                        <p class="greeting">你好！</p>
-                        <div class="card-notification">
-							<span class="highlight">` + fromNickname + `</span>同意了你的道友申请
-                            <br><br>
-                            <span class="highlight">` + fromEmail + `</span>
-                        </div>
-                        <div class="app-link">
-                            点击访问应用查看详情：<br><br>
-                            <a href="http://wangxiang-pro.top:18080/" target="_blank">点我查看吆🎀</a>
-                        </div>
-                        <p>快去体验专为情侣和朋友设计的互动卡片系统吧～</p>
-                    </div>
-                    <div class="footer">
-                        这是一封自动发送的通知邮件，无需回复
-                    </div>
-                </div>
-            </body>
-        </html>
-    `
-	return body
-}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"card-authorization/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 登录链接有效期
+	magicLinkTTL = 15 * time.Minute
+	// 每个账号每小时最多发送的登录邮件数
+	magicLinkHourlyLimit = 3
+)
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type MagicLoginRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// LoginMethods 登录页可用的登录方式
+func LoginMethods(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"password":   !config.SystemConfig.DisablePasswordLogin,
+		"magic_link": notify.EmailConfigured(),
+		"oidc":       oidcProviders(),
+	})
+}
+
+// RequestMagicLink 发送登录链接邮件
+// 无论邮箱是否存在都返回相同的结果，避免被用来探测注册邮箱
+func RequestMagicLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !notify.EmailConfigured() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "未配置邮件服务，不能使用邮件登录"})
+		return
+	}
+	resp := gin.H{"message": "如果该邮箱已注册，登录链接将很快送达，15分钟内有效"}
+
+	var user models.User
+	if err := database.DB.Where("email = ?", strings.TrimSpace(req.Email)).First(&user).Error; err != nil {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	now := time.Now()
+	var recent int64
+	database.DB.Model(&models.MagicLinkToken{}).
+		Where("user_id = ? AND created_at > ?", user.ID, now.Add(-time.Hour)).
+		Count(&recent)
+	if recent >= magicLinkHourlyLimit {
+		log.Warn("用户[%d]申请登录链接过于频繁", user.ID)
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	// 新的链接发出后，之前未使用的链接作废
+	database.DB.Model(&models.MagicLinkToken{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Update("used_at", now)
+
+	token := utils.RandomToken()
+	if err := database.DB.Create(&models.MagicLinkToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: now.Add(magicLinkTTL),
+		IP:        c.ClientIP(),
+	}).Error; err != nil {
+		log.Error("保存登录链接token失败: %v", err)
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if err := notify.SendAccountEmail(&user, "magic_link", map[string]any{
+		"Nickname":  user.Nickname,
+		"IP":        c.ClientIP(),
+		"ActionURL": config.SystemConfig.PublicURL + "/magic-login?token=" + url.QueryEscape(token),
+	}); err != nil {
+		log.Error("发送登录链接邮件失败: %v", err)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// MagicLogin 用邮件中的token登录，开启了两步验证时仍需输入验证码
+// 链接打开的是页面，由页面提交token，避免邮件客户端预取链接时把token用掉
+func MagicLogin(c *gin.Context) {
+	var req MagicLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var link models.MagicLinkToken
+	if err := database.DB.Where("token_hash = ?", utils.HashToken(req.Token)).First(&link).Error; err != nil ||
+		link.UsedAt != nil || time.Now().After(link.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "登录链接无效或已过期，请重新申请"})
+		return
+	}
+
+	now := time.Now()
+	// 用 used_at 做条件更新，同一个token并发提交时只有一个能成功
+	result := database.DB.Model(&models.MagicLinkToken{}).
+		Where("id = ? AND used_at IS NULL", link.ID).
+		Update("used_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "登录链接无效或已过期，请重新申请"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, link.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户不存在"})
+		return
+	}
+	// 能收到邮件说明邮箱有效
+	if user.EmailVerifiedAt == nil {
+		database.DB.Model(&user).Update("email_verified_at", now)
+	}
+
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": user.SuspensionMessage()})
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusOK, MFARequiredResponse{
+			MFARequired: true,
+			MFAToken:    signMFAToken(user.ID),
+			Message:     "请输入两步验证码",
+		})
+		return
+	}
+	completeLogin(c, &user, "magic_link")
+}
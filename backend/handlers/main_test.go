@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/internal/mockoidc"
+	"card-authorization/middleware"
+	"card-authorization/models"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testOIDCSecret = "mock-secret"
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+// runTests 使用临时数据库和测试配置运行测试
+func runTests(m *testing.M) int {
+	gin.SetMode(gin.TestMode)
+	dir, err := os.MkdirTemp("", "card-handlers")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if err := database.Open(filepath.Join(dir, "test.db") + "?_pragma=busy_timeout(5000)"); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	config.SystemConfig.SecretKey = "0123456789abcdef0123456789abcdef"
+	config.SystemConfig.PublicURL = "http://app.test"
+	config.SystemConfig.WebhookAllowedNetworks = []string{"127.0.0.1"}
+	config.SystemConfig.JWT.AccessTTLMinutes = 15
+	config.SystemConfig.JWT.RefreshTTLDays = 30
+	if err := middleware.InitJWTKeys(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	// 模拟的身份提供方，OIDC 客户端只初始化一次，所有测试共用
+	provider, err := mockoidc.New("", testOIDCSecret)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	srv := httptest.NewServer(provider)
+	defer srv.Close()
+	provider.Issuer = srv.URL
+	config.SystemConfig.OIDC = []config.OIDCProvider{{
+		Name:         "mock",
+		DisplayName:  "测试登录",
+		Issuer:       srv.URL,
+		ClientID:     "card-authorization",
+		ClientSecret: testOIDCSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+	}}
+	return m.Run()
+}
+
+var testUserSeq int
+
+// testEmail 生成不会与已有用户重复的邮箱
+func testEmail(name string) string {
+	testUserSeq++
+	return fmt.Sprintf("%s_%d@example.com", name, testUserSeq)
+}
+
+// createTestUser 创建用户，用户名加上序号，重复运行测试时不会冲突
+func createTestUser(t *testing.T, name string) *models.User {
+	t.Helper()
+	testUserSeq++
+	username := fmt.Sprintf("%s_%d", name, testUserSeq)
+	user := &models.User{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "123456", // BeforeCreate 中加密
+		Nickname: "N" + username,
+		Locale:   "zh-CN",
+	}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// serve 以 userID 的身份调用 handler，userID 为0时不登录
+func serve(handler gin.HandlerFunc, userID uint, method, path, routePath string, body any) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, routePath, func(c *gin.Context) {
+		if userID != 0 {
+			c.Set("userID", userID)
+		}
+		handler(c)
+	})
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var result map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("response is not JSON: %s", w.Body.String())
+	}
+	return result
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer        = "功能卡片授权"
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// MFARequiredResponse 密码正确但还需要两步验证时的登录响应
+type MFARequiredResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"` // 提交验证码时带上，5分钟内有效
+	Message     string `json:"message"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"` // 验证器中的6位验证码或恢复码
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type PasswordConfirmRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+func signMFAToken(userID uint) string {
+	return utils.SignToken([]byte(config.SystemConfig.SecretKey), "mfa_login", strconv.FormatUint(uint64(userID), 10), mfaTokenTTL)
+}
+
+// LoginMFA 登录的第二步，用临时token和验证码换取正式的token
+func LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payload, err := utils.VerifyToken([]byte(config.SystemConfig.SecretKey), "mfa_login", req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证已过期，请重新登录"})
+		return
+	}
+	userID, _ := strconv.ParseUint(payload, 10, 64)
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil || user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证已过期，请重新登录"})
+		return
+	}
+
+	defer lockLoginAttempt(user.Username, c.ClientIP())()
+	if rejectThrottledLogin(c, user.Username) {
+		return
+	}
+	if !verifySecondFactor(&user, req.Code) {
+		loginFailed(c, user.Username, &user, "mfa")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误"})
+		return
+	}
+	completeLogin(c, &user, "")
+}
+
+// verifySecondFactor 校验TOTP验证码或恢复码，两者都只能使用一次
+func verifySecondFactor(user *models.User, code string) bool {
+	if step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		// 条件更新，并发提交同一个验证码时只有一个能成功
+		result := database.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		return result.Error == nil && result.RowsAffected == 1
+	}
+
+	result := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(utils.NormalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	log.Info("用户[%d]使用恢复码登录", user.ID)
+	return true
+}
+
+// SetupTOTP 开始绑定验证器，返回密钥和 otpauth URI，需再用 EnableTOTP 确认
+func SetupTOTP(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已开启两步验证"})
+		return
+	}
+
+	secret := utils.GenerateTOTPSecret()
+	if err := database.DB.Model(&user).Update("totp_secret", secret).Error; err != nil {
+		log.Error("保存TOTP密钥失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启两步验证失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(totpIssuer, user.Username, secret),
+	})
+}
+
+// EnableTOTP 用验证器生成的第一个验证码确认绑定，返回恢复码
+// 恢复码只在这里显示一次
+func EnableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已开启两步验证"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请先获取验证器密钥"})
+		return
+	}
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, req.Code, time.Now(), 0)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{
+			"totp_enabled_at": time.Now(),
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		log.Error("开启两步验证失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "开启两步验证失败"})
+		return
+	}
+
+	log.Info("用户[%d]开启了两步验证", user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "两步验证已开启，请妥善保存恢复码",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP 关闭两步验证，需要输入密码
+func DisableTOTP(c *gin.Context) {
+	var req PasswordConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if !user.CheckPassword(req.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码错误"})
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		log.Error("关闭两步验证失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "关闭两步验证失败"})
+		return
+	}
+
+	log.Info("用户[%d]关闭了两步验证", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "两步验证已关闭"})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，之前的恢复码全部作废，需要输入密码
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req PasswordConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if !user.CheckPassword(req.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码错误"})
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未开启两步验证"})
+		return
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		log.Error("生成恢复码失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成恢复码失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已生成新的恢复码", "recovery_codes": codes})
+}
+
+// replaceRecoveryCodes 删除旧的恢复码并生成一组新的，返回明文
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = utils.GenerateRecoveryCode()
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: utils.HashToken(codes[i])}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListNotifications 我的通知，支持 unread=1 只看未读，page/page_size 分页
+func ListNotifications(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := database.DB.Model(&models.Notification{}).Where("user_id = ?", userID)
+	if c.Query("unread") == "1" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Error("获取通知失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取通知失败"})
+		return
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&notifications).Error; err != nil {
+		log.Error("获取通知失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取通知失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"total":         total,
+		"page":          page,
+		"page_size":     pageSize,
+	})
+}
+
+// CountUnreadNotifications 未读通知数
+func CountUnreadNotifications(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var count int64
+	if err := database.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Count(&count).Error; err != nil {
+		log.Error("获取未读通知数失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取未读通知数失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": count})
+}
+
+// ReadNotification 标记单条通知为已读
+func ReadNotification(c *gin.Context) {
+	userID := c.GetUint("userID")
+	notificationID := c.Param("id")
+
+	var notification models.Notification
+	if err := database.DB.Where("id = ? AND user_id = ?", notificationID, userID).First(&notification).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "通知不存在"})
+		return
+	}
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+		if err := database.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+			log.Error("标记通知已读失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "标记通知已读失败"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已读", "notification": notification})
+}
+
+// ReadAllNotifications 全部标记为已读
+func ReadAllNotifications(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	result := database.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		log.Error("标记通知已读失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "标记通知已读失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "全部已读", "updated": result.RowsAffected})
+}
+
+// DeleteNotification 删除单条通知
+func DeleteNotification(c *gin.Context) {
+	userID := c.GetUint("userID")
+	notificationID := c.Param("id")
+
+	result := database.DB.Where("id = ? AND user_id = ?", notificationID, userID).Delete(&models.Notification{})
+	if result.Error != nil {
+		log.Error("删除通知失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除通知失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "通知不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "通知已删除"})
+}
+
+// ClearReadNotifications 删除所有已读通知
+func ClearReadNotifications(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	result := database.DB.Where("user_id = ? AND read_at IS NOT NULL", userID).Delete(&models.Notification{})
+	if result.Error != nil {
+		log.Error("删除通知失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除通知失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已读通知已清空", "deleted": result.RowsAffected})
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"card-authorization/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// oidcState 授权请求的状态，签名后保存在 HttpOnly cookie 中，回调时取回
+type oidcState struct {
+	State      string
+	Nonce      string
+	Verifier   string // PKCE code_verifier
+	LinkUserID uint   // 不为0时表示给已登录用户绑定身份
+	Provider   string
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^\p{L}\p{N}_]+`)
+
+// OIDCProviderResponse 可用的第三方登录
+type OIDCProviderResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+func oidcProviders() []OIDCProviderResponse {
+	providers := []OIDCProviderResponse{}
+	for _, p := range config.SystemConfig.OIDC {
+		providers = append(providers, OIDCProviderResponse{Name: p.Name, DisplayName: p.DisplayName})
+	}
+	return providers
+}
+
+// OIDCLogin 跳转到身份提供方登录
+func OIDCLogin(c *gin.Context) {
+	authURL, err := startOIDC(c, c.Param("provider"), 0)
+	if err != nil {
+		redirectWithFragment(c, "/login", url.Values{"error": {err.Error()}})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// LinkIdentity 为当前用户绑定第三方身份，返回授权地址，由前端跳转
+func LinkIdentity(c *gin.Context) {
+	authURL, err := startOIDC(c, c.Param("provider"), c.GetUint("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"url": authURL})
+}
+
+// startOIDC 生成 state、nonce 和 PKCE 参数，写入cookie并返回授权地址
+func startOIDC(c *gin.Context, provider string, linkUserID uint) (string, error) {
+	client := utils.OIDCClientFor(provider)
+	if client == nil {
+		return "", errors.New("不支持的登录方式")
+	}
+	st := oidcState{
+		State:      utils.RandomToken(),
+		Nonce:      utils.RandomToken(),
+		Verifier:   utils.RandomToken(),
+		LinkUserID: linkUserID,
+		Provider:   provider,
+	}
+	authURL, err := client.AuthCodeURL(st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		log.Error("生成 %s 授权地址失败: %v", provider, err)
+		return "", errors.New("暂时无法连接登录服务，请稍后再试")
+	}
+
+	payload := strings.Join([]string{st.State, st.Nonce, st.Verifier, strconv.FormatUint(uint64(linkUserID), 10), provider}, ":")
+	token := utils.SignToken([]byte(config.SystemConfig.SecretKey), "oidc_state", payload, oidcStateTTL)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, token, int(oidcStateTTL.Seconds()), "/api/oidc", "",
+		strings.HasPrefix(config.SystemConfig.PublicURL, "https://"), true)
+	return authURL, nil
+}
+
+// readOIDCState 取回并清除cookie中的授权状态
+func readOIDCState(c *gin.Context) (*oidcState, error) {
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, errors.New("登录已超时，请重新登录")
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/api/oidc", "", false, true)
+	payload, err := utils.VerifyToken([]byte(config.SystemConfig.SecretKey), "oidc_state", cookie)
+	if err != nil {
+		return nil, errors.New("登录已超时，请重新登录")
+	}
+	parts := strings.SplitN(payload, ":", 5)
+	if len(parts) != 5 {
+		return nil, errors.New("登录已超时，请重新登录")
+	}
+	linkUserID, _ := strconv.ParseUint(parts[3], 10, 64)
+	return &oidcState{State: parts[0], Nonce: parts[1], Verifier: parts[2], LinkUserID: uint(linkUserID), Provider: parts[4]}, nil
+}
+
+// OIDCCallback 身份提供方登录后的回调
+// 登录成功后带着token跳转到登录页，token放在URL片段中，不会发送到服务器
+func OIDCCallback(c *gin.Context) {
+	provider := c.Param("provider")
+	st, err := readOIDCState(c)
+	failPage := "/login"
+	if err == nil && st.LinkUserID != 0 {
+		failPage = "/dashboard"
+	}
+	fail := func(message string) {
+		redirectWithFragment(c, failPage, url.Values{"error": {message}})
+	}
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	if st.Provider != provider || c.Query("state") != st.State {
+		fail("登录状态不匹配，请重新登录")
+		return
+	}
+	if errMsg := c.Query("error"); errMsg != "" {
+		fail("第三方登录失败: " + errMsg)
+		return
+	}
+
+	client := utils.OIDCClientFor(provider)
+	if client == nil {
+		fail("不支持的登录方式")
+		return
+	}
+	claims, err := client.Exchange(c.Query("code"), st.Verifier, st.Nonce)
+	if err != nil {
+		log.Error("%s 登录失败: %v", provider, err)
+		fail("第三方登录失败，请稍后再试")
+		return
+	}
+
+	if st.LinkUserID != 0 {
+		if err := linkIdentity(st.LinkUserID, provider, claims); err != nil {
+			fail(err.Error())
+			return
+		}
+		redirectWithFragment(c, "/dashboard", url.Values{"linked": {provider}})
+		return
+	}
+
+	user, err := userForIdentity(c, provider, claims)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	if user.SuspendedAt != nil {
+		fail(user.SuspensionMessage())
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		redirectWithFragment(c, "/login", url.Values{"mfa_token": {signMFAToken(user.ID)}})
+		return
+	}
+	tokens, err := loginSession(c, user, "oidc:"+provider)
+	if err != nil {
+		fail("登录失败，请稍后再试")
+		return
+	}
+	redirectWithFragment(c, "/login", url.Values{
+		"token":         {tokens.Token},
+		"refresh_token": {tokens.RefreshToken},
+	})
+}
+
+func redirectWithFragment(c *gin.Context, path string, values url.Values) {
+	c.Redirect(http.StatusFound, path+"#"+values.Encode())
+}
+
+// userForIdentity 按绑定关系找到用户；没有绑定时按已验证的邮箱关联已有用户或创建新用户
+func userForIdentity(c *gin.Context, provider string, claims *utils.OIDCClaims) (*models.User, error) {
+	var identity models.UserIdentity
+	if err := database.DB.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error; err == nil {
+		var user models.User
+		if err := database.DB.First(&user, identity.UserID).Error; err != nil {
+			return nil, errors.New("用户不存在")
+		}
+		return &user, nil
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("第三方账号的邮箱未验证，无法登录")
+	}
+
+	var user models.User
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Where("email = ?", claims.Email).First(&user).Error; err == nil {
+			// 第三方已验证过这个邮箱
+			if user.EmailVerifiedAt == nil {
+				if err := tx.Model(&user).Update("email_verified_at", now).Error; err != nil {
+					return err
+				}
+			}
+		} else {
+			username, err := availableUsername(tx, claims)
+			if err != nil {
+				return err
+			}
+			user = models.User{
+				Username: username,
+				Email:    claims.Email,
+				Password: utils.RandomToken(), // 没有密码，需要时可以通过忘记密码设置
+				Nickname: claims.Name,
+				Locale:   notify.LocaleFromAcceptLanguage(c.GetHeader("Accept-Language")),
+
+				EmailVerifiedAt: &now,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			log.Info("通过 %s 登录创建了用户[%d] %s", provider, user.ID, user.Username)
+		}
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	if err != nil {
+		log.Error("关联第三方身份失败: %v", err)
+		return nil, errors.New("登录失败，请稍后再试")
+	}
+	return &user, nil
+}
+
+// availableUsername 由第三方的用户名或邮箱生成一个未被占用的用户名
+func availableUsername(tx *gorm.DB, claims *utils.OIDCClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "_")
+	if runes := []rune(base); len(runes) > 24 {
+		base = string(runes[:24])
+	}
+	for len([]rune(base)) < 2 {
+		base += "_"
+	}
+
+	for i := 0; i < 100; i++ {
+		username := base
+		if i > 0 {
+			username = fmt.Sprintf("%s_%d", base, i)
+		}
+		var count int64
+		if err := tx.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return username, nil
+		}
+	}
+	return "", errors.New("无法生成用户名")
+}
+
+// linkIdentity 给已登录用户绑定第三方身份
+func linkIdentity(userID uint, provider string, claims *utils.OIDCClaims) error {
+	var existing models.UserIdentity
+	if err := database.DB.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&existing).Error; err == nil {
+		if existing.UserID == userID {
+			return nil
+		}
+		return errors.New("该第三方账号已绑定其他用户")
+	}
+	if err := database.DB.Create(&models.UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}).Error; err != nil {
+		log.Error("绑定第三方身份失败: %v", err)
+		return errors.New("绑定失败，请稍后再试")
+	}
+	log.Info("用户[%d]绑定了 %s 账号", userID, provider)
+	return nil
+}
+
+// ListIdentities 我绑定的第三方身份和可以绑定的身份提供方
+func ListIdentities(c *gin.Context) {
+	var identities []models.UserIdentity
+	if err := database.DB.Where("user_id = ?", c.GetUint("userID")).Order("id").Find(&identities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取绑定信息失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": identities, "providers": oidcProviders()})
+}
+
+// UnlinkIdentity 解除绑定
+func UnlinkIdentity(c *gin.Context) {
+	result := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("userID")).Delete(&models.UserIdentity{})
+	if result.Error != nil {
+		log.Error("解除绑定失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解除绑定失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "绑定不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已解除绑定"})
+}
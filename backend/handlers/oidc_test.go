@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/models"
+	"card-authorization/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startOIDCLogin 打开登录地址，返回身份提供方的授权地址和状态cookie
+func startOIDCLogin(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+	w := serve(OIDCLogin, 0, http.MethodGet, "/api/oidc/mock/login", "/api/oidc/:provider/login", nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("OIDCLogin = %d %s", w.Code, w.Body.String())
+	}
+	return w.Header().Get("Location"), oidcStateCookieFrom(t, w)
+}
+
+// startOIDCLink 已登录用户发起绑定
+func startOIDCLink(t *testing.T, userID uint) (string, *http.Cookie) {
+	t.Helper()
+	w := serve(LinkIdentity, userID, http.MethodPost, "/api/oidc/mock/link", "/api/oidc/:provider/link", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("LinkIdentity = %d %s", w.Code, w.Body.String())
+	}
+	return decodeJSON(t, w)["url"].(string), oidcStateCookieFrom(t, w)
+}
+
+func oidcStateCookieFrom(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return cookie
+		}
+	}
+	t.Fatal("oidc_state cookie not set")
+	return nil
+}
+
+// authorizeOIDC 以 email 在身份提供方登录，override 可以篡改授权请求的参数，返回回调的查询参数
+func authorizeOIDC(t *testing.T, authURL, email string, override url.Values) url.Values {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("login_hint", email)
+	for k, v := range override {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize = %s", resp.Status)
+	}
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(redirect.Path, "/api/oidc/mock/callback") {
+		t.Fatalf("redirect_uri = %s", redirect)
+	}
+	return redirect.Query()
+}
+
+// oidcCallback 带着状态cookie请求回调，返回跳转的页面和URL片段中的参数
+func oidcCallback(t *testing.T, cookie *http.Cookie, query url.Values) (string, url.Values) {
+	t.Helper()
+	r := gin.New()
+	r.GET("/api/oidc/:provider/callback", OIDCCallback)
+	req := httptest.NewRequest(http.MethodGet, "/api/oidc/mock/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("OIDCCallback = %d %s", w.Code, w.Body.String())
+	}
+	path, fragment, _ := strings.Cut(w.Header().Get("Location"), "#")
+	values, err := url.ParseQuery(fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, values
+}
+
+func identityCount(subject string) int64 {
+	var count int64
+	database.DB.Model(&models.UserIdentity{}).Where("provider = ? AND subject = ?", "mock", subject).Count(&count)
+	return count
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	email := testEmail("oidc_new")
+	authURL, cookie := startOIDCLogin(t)
+	path, values := oidcCallback(t, cookie, authorizeOIDC(t, authURL, email, url.Values{"name": {"新用户"}}))
+	if path != "/login" || values.Get("token") == "" || values.Get("refresh_token") == "" {
+		t.Fatalf("callback = %s %v", path, values)
+	}
+
+	var user models.User
+	if err := database.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.EmailVerifiedAt == nil || user.Nickname != "新用户" || !strings.HasPrefix(user.Username, "oidc_new") {
+		t.Errorf("user = %+v", user)
+	}
+	if identityCount("mock|"+email) != 1 {
+		t.Error("identity not recorded")
+	}
+
+	// 再次登录使用已绑定的用户
+	authURL, cookie = startOIDCLogin(t)
+	_, values = oidcCallback(t, cookie, authorizeOIDC(t, authURL, email, nil))
+	if values.Get("token") == "" {
+		t.Fatalf("second login = %v", values)
+	}
+	var count int64
+	database.DB.Model(&models.User{}).Where("email = ?", email).Count(&count)
+	if count != 1 || identityCount("mock|"+email) != 1 {
+		t.Errorf("second login created duplicates: users=%d", count)
+	}
+}
+
+func TestOIDCLoginLinksExistingUserByVerifiedEmail(t *testing.T) {
+	user := createTestUser(t, "oidc_existing")
+	authURL, cookie := startOIDCLogin(t)
+	_, values := oidcCallback(t, cookie, authorizeOIDC(t, authURL, user.Email, nil))
+	if values.Get("token") == "" {
+		t.Fatalf("callback = %v", values)
+	}
+	var identity models.UserIdentity
+	if err := database.DB.Where("provider = ? AND subject = ?", "mock", "mock|"+user.Email).First(&identity).Error; err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserID != user.ID {
+		t.Errorf("identity.UserID = %d, want %d", identity.UserID, user.ID)
+	}
+}
+
+func TestOIDCLoginRejectsUnverifiedEmail(t *testing.T) {
+	user := createTestUser(t, "oidc_unverified")
+	authURL, cookie := startOIDCLogin(t)
+	_, values := oidcCallback(t, cookie, authorizeOIDC(t, authURL, user.Email, url.Values{"unverified": {"1"}}))
+	if values.Get("token") != "" || values.Get("error") == "" {
+		t.Fatalf("callback = %v", values)
+	}
+	if identityCount("mock|"+user.Email) != 0 {
+		t.Error("unverified email was linked to an existing user")
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	email := testEmail("oidc_state")
+
+	authURL, cookie := startOIDCLogin(t)
+	query := authorizeOIDC(t, authURL, email, nil)
+	query.Set("state", "forged")
+	if _, values := oidcCallback(t, cookie, query); values.Get("error") != "登录状态不匹配，请重新登录" {
+		t.Errorf("forged state: %v", values)
+	}
+
+	authURL, _ = startOIDCLogin(t)
+	if _, values := oidcCallback(t, nil, authorizeOIDC(t, authURL, email, nil)); values.Get("error") != "登录已超时，请重新登录" {
+		t.Errorf("missing cookie: %v", values)
+	}
+
+	authURL, cookie = startOIDCLogin(t)
+	cookie.Value += "x"
+	if _, values := oidcCallback(t, cookie, authorizeOIDC(t, authURL, email, nil)); values.Get("error") != "登录已超时，请重新登录" {
+		t.Errorf("tampered cookie: %v", values)
+	}
+	if identityCount("mock|"+email) != 0 {
+		t.Error("identity created despite invalid state")
+	}
+}
+
+func TestOIDCCallbackChecksNonce(t *testing.T) {
+	email := testEmail("oidc_nonce")
+	authURL, cookie := startOIDCLogin(t)
+	// 授权码由另一个 nonce 的授权请求获得，例如被攻击者注入
+	_, values := oidcCallback(t, cookie, authorizeOIDC(t, authURL, email, url.Values{"nonce": {"attacker"}}))
+	if values.Get("token") != "" || values.Get("error") != "第三方登录失败，请稍后再试" {
+		t.Fatalf("callback = %v", values)
+	}
+	if identityCount("mock|"+email) != 0 {
+		t.Error("identity created despite nonce mismatch")
+	}
+}
+
+func TestOIDCCallbackChecksPKCEVerifier(t *testing.T) {
+	email := testEmail("oidc_pkce")
+	authURL, cookie := startOIDCLogin(t)
+	// 授权码绑定的是另一个 code_verifier，cookie 中的 verifier 换不到 token
+	override := url.Values{"code_challenge": {utils.PKCEChallenge("another-verifier")}}
+	_, values := oidcCallback(t, cookie, authorizeOIDC(t, authURL, email, override))
+	if values.Get("token") != "" || values.Get("error") != "第三方登录失败，请稍后再试" {
+		t.Fatalf("callback = %v", values)
+	}
+	if identityCount("mock|"+email) != 0 {
+		t.Error("identity created despite verifier mismatch")
+	}
+}
+
+func TestOIDCLinkIdentity(t *testing.T) {
+	user := createTestUser(t, "oidc_link")
+	email := testEmail("oidc_link_other")
+
+	authURL, cookie := startOIDCLink(t, user.ID)
+	path, values := oidcCallback(t, cookie, authorizeOIDC(t, authURL, email, nil))
+	if path != "/dashboard" || values.Get("linked") != "mock" {
+		t.Fatalf("callback = %s %v", path, values)
+	}
+	var identity models.UserIdentity
+	if err := database.DB.Where("provider = ? AND subject = ?", "mock", "mock|"+email).First(&identity).Error; err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserID != user.ID {
+		t.Errorf("identity.UserID = %d, want %d", identity.UserID, user.ID)
+	}
+
+	// 同一个第三方账号不能再绑定给其他用户
+	other := createTestUser(t, "oidc_link_dup")
+	authURL, cookie = startOIDCLink(t, other.ID)
+	path, values = oidcCallback(t, cookie, authorizeOIDC(t, authURL, email, nil))
+	if path != "/dashboard" || values.Get("error") != "该第三方账号已绑定其他用户" {
+		t.Fatalf("duplicate link = %s %v", path, values)
+	}
+
+	// 绑定后用该第三方账号登录的是绑定的用户，而不是按邮箱新建用户
+	authURL, cookie = startOIDCLogin(t)
+	_, values = oidcCallback(t, cookie, authorizeOIDC(t, authURL, email, nil))
+	if values.Get("token") == "" {
+		t.Fatalf("login = %v", values)
+	}
+	var count int64
+	database.DB.Model(&models.User{}).Where("email = ?", email).Count(&count)
+	if count != 0 {
+		t.Error("login after linking created a new user")
+	}
+}
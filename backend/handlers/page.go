@@ -47,3 +47,21 @@ func Friends(c *gin.Context) {
 		"title": "我的道友 - 功能卡片授权",
 	})
 }
+
+func ForgotPasswordPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "forgot_password.html", gin.H{
+		"title": "忘记密码 - 功能卡片授权",
+	})
+}
+
+func ResetPasswordPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "reset_password.html", gin.H{
+		"title": "重置密码 - 功能卡片授权",
+	})
+}
+
+func MagicLoginPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "magic_login.html", gin.H{
+		"title": "邮件登录 - 功能卡片授权",
+	})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"card-authorization/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 重置密码链接有效期
+	passwordResetTTL = 30 * time.Minute
+	// 每个账号每小时最多发送的重置邮件数
+	passwordResetHourlyLimit = 3
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// ForgotPassword 发送重置密码邮件
+// 无论邮箱是否存在都返回相同的结果，避免被用来探测注册邮箱
+func ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp := gin.H{"message": "如果该邮箱已注册，重置密码的邮件将很快送达"}
+
+	var user models.User
+	if err := database.DB.Where("email = ?", strings.TrimSpace(req.Email)).First(&user).Error; err != nil {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	now := time.Now()
+	var recent int64
+	database.DB.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND created_at > ?", user.ID, now.Add(-time.Hour)).
+		Count(&recent)
+	if recent >= passwordResetHourlyLimit {
+		log.Warn("用户[%d]重置密码请求过于频繁", user.ID)
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	// 新的链接发出后，之前未使用的链接作废
+	database.DB.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Update("used_at", now)
+
+	token := utils.RandomToken()
+	if err := database.DB.Create(&models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: now.Add(passwordResetTTL),
+		IP:        c.ClientIP(),
+	}).Error; err != nil {
+		log.Error("保存重置密码token失败: %v", err)
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if err := notify.SendAccountEmail(&user, "reset_password", map[string]any{
+		"Nickname":  user.Nickname,
+		"ActionURL": config.SystemConfig.PublicURL + "/reset-password?token=" + url.QueryEscape(token),
+	}); err != nil {
+		log.Error("发送重置密码邮件失败: %v", err)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ResetPassword 用邮件中的token设置新密码，成功后所有已登录的会话失效
+func ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var reset models.PasswordResetToken
+	if err := database.DB.Where("token_hash = ?", utils.HashToken(req.Token)).First(&reset).Error; err != nil ||
+		reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "重置链接无效或已过期，请重新申请"})
+		return
+	}
+
+	hashedPassword, err := models.HashPassword(req.Password)
+	if err != nil {
+		log.Error("加密密码失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置密码失败"})
+		return
+	}
+
+	now := time.Now()
+	// 用 used_at 做条件更新，同一个token并发提交时只有一个能成功
+	result := database.DB.Model(&models.PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", reset.ID).
+		Update("used_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "重置链接无效或已过期，请重新申请"})
+		return
+	}
+	if err := database.DB.Model(&models.User{}).Where("id = ?", reset.UserID).Update("password", hashedPassword).Error; err != nil {
+		log.Error("重置密码失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置密码失败"})
+		return
+	}
+	if err := revokeSessions(database.DB, reset.UserID, 0, models.SessionRevokedPasswordReset); err != nil {
+		log.Error("注销会话失败: %v", err)
+	}
+	// 账号可能已经泄露，个人访问令牌一并撤销
+	if err := revokeAccessTokens(database.DB, reset.UserID); err != nil {
+		log.Error("撤销个人访问令牌失败: %v", err)
+	}
+
+	log.Info("用户[%d]通过邮件重置了密码", reset.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "密码已重置，请使用新密码登录"})
+}
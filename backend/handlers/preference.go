@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"card-authorization/utils"
+	"errors"
+	"html/template"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+type PreferenceItem struct {
+	Event   models.ActivityType `json:"event" binding:"required"`
+	Email   bool                `json:"email"`
+	InApp   bool                `json:"in_app"`
+	Webhook bool                `json:"webhook"`
+}
+
+type UpdatePreferencesRequest struct {
+	Preferences []PreferenceItem `json:"preferences"`
+	Timezone    *string          `json:"timezone"`
+	QuietStart  *string          `json:"quiet_start"` // HH:MM，和 quiet_end 同时为空表示关闭免打扰
+	QuietEnd    *string          `json:"quiet_end"`
+	DigestMode  *string          `json:"digest_mode"` // off、daily、weekly
+}
+
+// GetNotificationPreferences 我的通知偏好
+func GetNotificationPreferences(c *gin.Context) {
+	userID := c.GetUint("userID")
+	c.JSON(http.StatusOK, buildPreferencesResponse(userID))
+}
+
+// UpdateNotificationPreferences 修改通知偏好和免打扰时段
+func UpdateNotificationPreferences(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var req UpdatePreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, item := range req.Preferences {
+		if !slices.ContainsFunc(notify.PreferenceEvents, func(e notify.PreferenceEvent) bool { return e.Event == item.Event }) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未知的事件类型: " + string(item.Event)})
+			return
+		}
+	}
+
+	setting := notify.SettingFor(userID)
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+			return
+		}
+		setting.Timezone = *req.Timezone
+	}
+	if req.QuietStart != nil || req.QuietEnd != nil {
+		start, end := "", ""
+		if req.QuietStart != nil {
+			start = *req.QuietStart
+		}
+		if req.QuietEnd != nil {
+			end = *req.QuietEnd
+		}
+		if start != "" || end != "" {
+			if !notify.ValidClock(start) || !notify.ValidClock(end) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "免打扰时间格式应为 HH:MM"})
+				return
+			}
+		}
+		setting.QuietStart, setting.QuietEnd = start, end
+	}
+
+	if req.DigestMode != nil && *req.DigestMode != setting.DigestMode {
+		if !notify.ValidDigestMode(*req.DigestMode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "摘要模式应为 off、daily 或 weekly"})
+			return
+		}
+		// 开启前的事件已经逐条发过邮件，摘要从现在开始统计
+		now := time.Now()
+		setting.DigestMode = *req.DigestMode
+		setting.LastDigestAt = &now
+	}
+
+	for _, item := range req.Preferences {
+		if err := notify.SavePreference(&models.NotificationPreference{
+			UserID:  userID,
+			Event:   item.Event,
+			Email:   item.Email,
+			InApp:   item.InApp,
+			Webhook: item.Webhook,
+		}); err != nil {
+			log.Error("保存通知偏好失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存通知偏好失败"})
+			return
+		}
+	}
+	if err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"timezone", "quiet_start", "quiet_end", "digest_mode", "last_digest_at", "updated_at"}),
+	}).Create(&setting).Error; err != nil {
+		log.Error("保存通知设置失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存通知设置失败"})
+		return
+	}
+
+	resp := buildPreferencesResponse(userID)
+	resp["message"] = "通知偏好已保存"
+	c.JSON(http.StatusOK, resp)
+}
+
+// unsubscribePageTemplate 退订确认页，表单提交到当前地址，退订链接中的token随查询参数一起提交
+var unsubscribePageTemplate = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html><html><head><meta charset="UTF-8"><title>邮件退订</title></head>` +
+	`<body style="font-family:'Helvetica Neue',Arial,sans-serif;text-align:center;padding:60px 20px;color:#333;">` +
+	`<p>确定不再接收“{{.}}”邮件吗？可以随时在通知设置中重新开启。</p>` +
+	`<form method="post"><button type="submit" style="padding:8px 24px;">确认退订</button></form></body></html>`))
+
+// UnsubscribeEmailPage 邮件中的退订链接，只显示确认页面，无需登录
+// 邮件安全网关和链接预览会自动打开链接，GET 请求不能修改设置
+func UnsubscribeEmailPage(c *gin.Context) {
+	label, err := notify.UnsubscribeLabel(c.Query("token"))
+	if err != nil {
+		unsubscribeFailed(c, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := unsubscribePageTemplate.Execute(&buf, label); err != nil {
+		log.Error("渲染退订页失败: %v", err)
+		c.String(http.StatusInternalServerError, "页面渲染失败")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// UnsubscribeEmail 确认退订，也接受邮件客户端按 List-Unsubscribe-Post（RFC 8058）发起的一键退订，无需登录
+func UnsubscribeEmail(c *gin.Context) {
+	event, err := notify.Unsubscribe(c.Query("token"))
+	if err != nil {
+		unsubscribeFailed(c, err)
+		return
+	}
+	log.Info("邮件退订: %s", event)
+	messagePage(c, http.StatusOK, "邮件退订", "退订成功，你将不再收到此类邮件。可以在通知设置中重新开启。")
+}
+
+// unsubscribeFailed 退订链接无效或过期时的结果页
+func unsubscribeFailed(c *gin.Context, err error) {
+	message := "退订链接无效。"
+	if errors.Is(err, utils.ErrTokenExpired) {
+		message = "退订链接已过期，请登录后在通知设置中修改。"
+	}
+	messagePage(c, http.StatusBadRequest, "邮件退订", message)
+}
+
+func buildPreferencesResponse(userID uint) gin.H {
+	type preferenceView struct {
+		notify.PreferenceEvent
+		Email   bool `json:"email"`
+		InApp   bool `json:"in_app"`
+		Webhook bool `json:"webhook"`
+	}
+	preferences := make([]preferenceView, 0, len(notify.PreferenceEvents))
+	for _, e := range notify.PreferenceEvents {
+		pref := notify.PreferenceFor(userID, e.Event)
+		preferences = append(preferences, preferenceView{e, pref.Email, pref.InApp, pref.Webhook})
+	}
+	setting := notify.SettingFor(userID)
+	return gin.H{
+		"preferences": preferences,
+		"timezone":    setting.Timezone,
+		"quiet_start": setting.QuietStart,
+		"quiet_end":   setting.QuietEnd,
+		"digest_mode": setting.DigestMode,
+	}
+}
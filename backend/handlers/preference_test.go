@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"card-authorization/models"
+	"card-authorization/notify"
+	"card-authorization/utils"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnsubscribeNeedsConfirmation(t *testing.T) {
+	user := createTestUser(t, "unsubscribe")
+	link := strings.TrimPrefix(notify.UnsubscribeURL(user.ID, models.ActivityCardSend), "http://app.test")
+
+	w := serve(UnsubscribeEmailPage, 0, http.MethodGet, link, "/api/notifications/unsubscribe", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post">`) {
+		t.Fatalf("GET = %d %s", w.Code, w.Body.String())
+	}
+	if !notify.PreferenceFor(user.ID, models.ActivityCardSend).Email {
+		t.Fatal("GET must not change the preference")
+	}
+
+	// 邮件客户端的一键退订按 RFC 8058 提交 List-Unsubscribe=One-Click
+	w = serve(UnsubscribeEmail, 0, http.MethodPost, link, "/api/notifications/unsubscribe", "List-Unsubscribe=One-Click")
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST = %d %s", w.Code, w.Body.String())
+	}
+	if notify.PreferenceFor(user.ID, models.ActivityCardSend).Email {
+		t.Error("POST did not turn off the email notification")
+	}
+
+	w = serve(UnsubscribeEmailPage, 0, http.MethodGet, "/api/notifications/unsubscribe?token=bad", "/api/notifications/unsubscribe", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("GET with bad token = %d", w.Code)
+	}
+}
+
+func TestNotificationMailHasListUnsubscribeHeaders(t *testing.T) {
+	msg := string(utils.BuildMessage("from@example.com", "to@example.com", "s", "<p>b</p>", "b", "http://app.test/u?token=x"))
+	for _, header := range []string{
+		"List-Unsubscribe: <http://app.test/u?token=x>\r\n",
+		"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n",
+	} {
+		if !strings.Contains(msg, header) {
+			t.Errorf("message has no %q", header)
+		}
+	}
+	if msg := string(utils.BuildMessage("from@example.com", "to@example.com", "s", "<p>b</p>", "b", "")); strings.Contains(msg, "List-Unsubscribe") {
+		t.Error("account mail must not have List-Unsubscribe")
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 登录限流参数
+// 同一用户名连续失败 loginDelayAfter 次后，每次重试需要等待的时间翻倍，最长 loginMaxDelay；
+// 失败 loginLockAfter 次后锁定 loginLockDuration。同一IP在 loginWindow 内失败 ipFailureLimit 次后暂时拒绝
+const (
+	loginWindow       = 15 * time.Minute
+	loginDelayAfter   = 3
+	loginMaxDelay     = time.Minute
+	loginLockAfter    = 10
+	loginLockDuration = 15 * time.Minute
+	ipFailureLimit    = 30
+)
+
+// recordSecurityEvent 记录一条安全事件，失败只记日志
+func recordSecurityEvent(c *gin.Context, eventType models.SecurityEventType, username string, user *models.User, detail string) {
+	event := models.SecurityEvent{
+		Username:  username,
+		IP:        c.ClientIP(),
+		Type:      eventType,
+		Detail:    detail,
+		UserAgent: c.Request.UserAgent(),
+	}
+	if user != nil {
+		event.UserID = &user.ID
+	}
+	if err := database.DB.Create(&event).Error; err != nil {
+		log.Error("记录安全事件失败: %v", err)
+	}
+}
+
+// lastSecurityEvent 用户名最近一次某类事件的时间，没有时返回零值
+func lastSecurityEvent(username string, eventType models.SecurityEventType) time.Time {
+	var event models.SecurityEvent
+	if err := database.DB.Where("username = ? AND type = ?", username, eventType).
+		Order("created_at DESC").First(&event).Error; err != nil {
+		return time.Time{}
+	}
+	return event.CreatedAt
+}
+
+// usernameFailures 用户名在窗口内、最近一次成功登录或锁定之后的连续失败次数和最后一次失败时间
+func usernameFailures(username string, now time.Time) (int64, time.Time) {
+	since := now.Add(-loginWindow)
+	for _, eventType := range []models.SecurityEventType{models.SecurityLoginSuccess, models.SecurityAccountLocked} {
+		if last := lastSecurityEvent(username, eventType); last.After(since) {
+			since = last
+		}
+	}
+
+	var count int64
+	database.DB.Model(&models.SecurityEvent{}).
+		Where("username = ? AND type = ? AND created_at > ?", username, models.SecurityLoginFailed, since).
+		Count(&count)
+	return count, lastSecurityEvent(username, models.SecurityLoginFailed)
+}
+
+// loginRetryAfter 检查是否允许这次登录尝试，不允许时返回需要等待的时间
+// 在校验密码之前调用，被拒绝的尝试不运行 bcrypt，也不计入失败次数
+// 调用方需持有 lockLoginAttempt 的锁，直到 loginFailed 记录完失败
+func loginRetryAfter(username, ip string) (time.Duration, string) {
+	now := time.Now()
+
+	// 账号被锁定
+	if locked := lastSecurityEvent(username, models.SecurityAccountLocked); !locked.IsZero() {
+		if wait := locked.Add(loginLockDuration).Sub(now); wait > 0 {
+			return wait, "账号已被临时锁定，请稍后再试"
+		}
+	}
+
+	// 连续失败后逐步延长等待时间
+	if failures, last := usernameFailures(username, now); failures >= loginDelayAfter {
+		delay := time.Duration(math.Pow(2, float64(failures-loginDelayAfter))) * time.Second
+		if delay > loginMaxDelay {
+			delay = loginMaxDelay
+		}
+		if wait := last.Add(delay).Sub(now); wait > 0 {
+			return wait, "登录失败次数过多，请稍后再试"
+		}
+	}
+
+	// 同一IP尝试了太多次
+	var ipFailures []models.SecurityEvent
+	database.DB.Where("ip = ? AND type = ? AND created_at > ?", ip, models.SecurityLoginFailed, now.Add(-loginWindow)).
+		Order("created_at DESC").Limit(ipFailureLimit).Find(&ipFailures)
+	if len(ipFailures) >= ipFailureLimit {
+		// 等到最早的一次失败移出窗口
+		oldest := ipFailures[len(ipFailures)-1].CreatedAt
+		if wait := oldest.Add(loginWindow).Sub(now); wait > 0 {
+			return wait, "登录失败次数过多，请稍后再试"
+		}
+	}
+	return 0, ""
+}
+
+// loginAttemptLocks 按用户名和IP分片的锁，同一用户名或IP的登录尝试串行执行，
+// 否则并发的错误尝试都能通过 loginRetryAfter 的检查，失败次数会超过限制
+var loginAttemptLocks [256]sync.Mutex
+
+// lockLoginAttempt 锁住用户名和IP对应的分片，直到记录完这次尝试的结果，返回解锁函数
+// 总是按分片序号从小到大加锁，不会死锁
+func lockLoginAttempt(username, ip string) func() {
+	shards := []int{loginAttemptShard("user:" + username), loginAttemptShard("ip:" + ip)}
+	slices.Sort(shards)
+	shards = slices.Compact(shards)
+	for _, i := range shards {
+		loginAttemptLocks[i].Lock()
+	}
+	return func() {
+		for _, i := range shards {
+			loginAttemptLocks[i].Unlock()
+		}
+	}
+}
+
+func loginAttemptShard(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(loginAttemptLocks)))
+}
+
+// rejectThrottledLogin 登录尝试被限流时返回429并带上 Retry-After
+func rejectThrottledLogin(c *gin.Context, username string) bool {
+	wait, message := loginRetryAfter(username, c.ClientIP())
+	if wait <= 0 {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": message})
+	return true
+}
+
+// loginFailed 记录一次失败的登录，达到次数后锁定账号并通知用户
+func loginFailed(c *gin.Context, username string, user *models.User, detail string) {
+	recordSecurityEvent(c, models.SecurityLoginFailed, username, user, detail)
+
+	failures, _ := usernameFailures(username, time.Now())
+	if failures < loginLockAfter {
+		return
+	}
+	recordSecurityEvent(c, models.SecurityAccountLocked, username, user, fmt.Sprintf("连续失败%d次", failures))
+	log.Warn("用户名 %s 连续登录失败%d次，锁定%v", username, failures, loginLockDuration)
+	if user == nil {
+		return
+	}
+	if err := notify.SendAccountEmail(user, "account_locked", map[string]any{
+		"Nickname":  user.Nickname,
+		"IP":        c.ClientIP(),
+		"Time":      time.Now().Format("2006-01-02 15:04:05"),
+		"Minutes":   int(loginLockDuration.Minutes()),
+		"ActionURL": config.SystemConfig.PublicURL + "/forgot-password",
+	}); err != nil {
+		log.Error("发送账号锁定通知失败: %v", err)
+	}
+}
+
+// ListSecurityEvents 当前用户最近的登录记录
+func ListSecurityEvents(c *gin.Context) {
+	var events []models.SecurityEvent
+	if err := database.DB.Where("user_id = ?", c.GetUint("userID")).
+		Order("created_at DESC").Limit(50).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取登录记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/models"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrentBadLoginsAreThrottled(t *testing.T) {
+	user := createTestUser(t, "login_race")
+
+	const attempts = 20
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := serve(Login, 0, http.MethodPost, "/api/login", "/api/login",
+				gin.H{"username": user.Username, "password": "wrong-password"})
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	// 前几次失败后开始要求等待，并发的尝试不能都通过检查
+	if counts[http.StatusUnauthorized] != loginDelayAfter || counts[http.StatusTooManyRequests] != attempts-loginDelayAfter {
+		t.Errorf("status counts = %v, want %d × 401 and %d × 429", counts, loginDelayAfter, attempts-loginDelayAfter)
+	}
+	var failures int64
+	database.DB.Model(&models.SecurityEvent{}).
+		Where("username = ? AND type = ?", user.Username, models.SecurityLoginFailed).Count(&failures)
+	if failures != loginDelayAfter {
+		t.Errorf("recorded %d failures, want %d", failures, loginDelayAfter)
+	}
+}
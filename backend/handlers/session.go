@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/middleware"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"card-authorization/utils"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 不输入当前密码就做敏感操作（注销账号、修改密码和邮箱）时，当前会话需在这段时间内登录
+// 第三方登录和邮件登录的用户不知道密码，只能靠重新登录来确认身份
+const recentLoginWindow = 10 * time.Minute
+
+// TokenResponse 登录和刷新时返回的token
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // 访问token的有效秒数
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func refreshTokenTTL() time.Duration {
+	return time.Duration(config.SystemConfig.JWT.RefreshTTLDays) * 24 * time.Hour
+}
+
+// startSession 登录成功后创建会话，签发访问token和刷新token
+func startSession(c *gin.Context, userID uint) (*TokenResponse, error) {
+	now := time.Now()
+	userAgent := c.Request.UserAgent()
+	session := models.Session{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		Device:     deviceName(userAgent),
+		IP:         c.ClientIP(),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL()),
+	}
+	var refreshToken string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		refreshToken, err = issueRefreshToken(tx, &session, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessionTokens(&session, refreshToken)
+}
+
+// issueRefreshToken 为会话生成新的刷新token并顺延会话有效期
+func issueRefreshToken(tx *gorm.DB, session *models.Session, now time.Time) (string, error) {
+	token := utils.RandomToken()
+	session.ExpiresAt = now.Add(refreshTokenTTL())
+	if err := tx.Create(&models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: session.ExpiresAt,
+	}).Error; err != nil {
+		return "", err
+	}
+	if err := tx.Model(session).Update("expires_at", session.ExpiresAt).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func sessionTokens(session *models.Session, refreshToken string) (*TokenResponse, error) {
+	token, err := middleware.GenerateToken(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(middleware.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// revokeSessions 注销用户的会话，exceptID 不为0时保留该会话
+func revokeSessions(db *gorm.DB, userID, exceptID uint, reason string) error {
+	query := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if exceptID != 0 {
+		query = query.Where("id <> ?", exceptID)
+	}
+	return query.Updates(map[string]any{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+func revokeSession(sessionID uint, reason string) error {
+	return database.DB.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]any{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+// RefreshSession 用刷新token换一对新的token，旧的刷新token随即作废
+// 已经用过的刷新token再次出现说明被盗用，整个会话立即注销
+func RefreshSession(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	var refresh models.RefreshToken
+	var session models.Session
+	if err := database.DB.Where("token_hash = ?", utils.HashToken(req.RefreshToken)).First(&refresh).Error; err != nil ||
+		database.DB.First(&session, refresh.SessionID).Error != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "刷新token无效，请重新登录"})
+		return
+	}
+	if session.RevokedAt != nil || now.After(session.ExpiresAt) || now.After(refresh.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+
+	var token string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// 条件更新保证同一个刷新token只能成功使用一次
+		result := tx.Model(&refresh).Where("used_at IS NULL").Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+		var err error
+		token, err = issueRefreshToken(tx, &session, now)
+		return err
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		if err := revokeSession(session.ID, models.SessionRevokedTokenReuse); err != nil {
+			log.Error("注销会话失败: %v", err)
+		}
+		log.Warn("用户[%d]的会话[%d]刷新token被重复使用，已注销该会话", session.UserID, session.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+	if err != nil {
+		log.Error("刷新token失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "刷新token失败"})
+		return
+	}
+
+	tokens, err := sessionTokens(&session, token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成token失败"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// Logout 注销当前会话
+func Logout(c *gin.Context) {
+	if err := revokeSession(c.GetUint("sessionID"), models.SessionRevokedLogout); err != nil {
+		log.Error("注销会话失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+// LogoutAll 注销当前用户的所有会话，包括当前会话
+func LogoutAll(c *gin.Context) {
+	userID := c.GetUint("userID")
+	if err := revokeSessions(database.DB, userID, 0, models.SessionRevokedLogoutAll); err != nil {
+		log.Error("注销会话失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+		return
+	}
+	log.Info("用户[%d]退出了所有设备", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "已退出所有设备"})
+}
+
+// SessionResponse 登录设备列表中的一项
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"` // 是否为发起请求的会话
+}
+
+// ListSessions 当前用户仍然有效的登录会话，最近访问的在前
+func ListSessions(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var sessions []models.Session
+	if err := database.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取登录设备失败"})
+		return
+	}
+
+	current := c.GetUint("sessionID")
+	resp := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, SessionResponse{Session: session, Current: session.ID == current})
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": resp})
+}
+
+// RevokeSession 注销自己的某个登录会话，注销当前会话等同于退出登录
+func RevokeSession(c *gin.Context) {
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
+		return
+	}
+
+	result := database.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, c.GetUint("userID")).
+		Updates(map[string]any{"revoked_at": time.Now(), "revoked_reason": models.SessionRevokedByUser})
+	if result.Error != nil {
+		log.Error("注销会话失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注销会话失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在或已注销"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已注销该设备"})
+}
+
+// notifyNewDevice 在从未登录过的设备上登录时发邮件提醒，设备按 User-Agent 区分
+// 需在创建本次会话之前调用
+func notifyNewDevice(c *gin.Context, user *models.User) {
+	var count int64
+	database.DB.Model(&models.Session{}).Where("user_id = ?", user.ID).Count(&count)
+	if count == 0 {
+		// 注册后的第一次登录不提醒
+		return
+	}
+	database.DB.Model(&models.Session{}).
+		Where("user_id = ? AND user_agent = ?", user.ID, c.Request.UserAgent()).
+		Count(&count)
+	if count > 0 {
+		return
+	}
+	if err := notify.SendAccountEmail(user, "new_login", map[string]any{
+		"Nickname":  user.Nickname,
+		"Device":    deviceName(c.Request.UserAgent()),
+		"IP":        c.ClientIP(),
+		"Time":      time.Now().Format("2006-01-02 15:04:05"),
+		"ActionURL": config.SystemConfig.PublicURL + "/dashboard",
+	}); err != nil {
+		log.Error("发送新设备登录提醒失败: %v", err)
+	}
+}
+
+// deviceName 从 User-Agent 粗略识别浏览器和操作系统
+func deviceName(userAgent string) string {
+	browser := "未知浏览器"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	system := "未知系统"
+	for _, s := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+	return browser + " / " + system
+}
+
+// recentlyLoggedIn 当前会话是否刚登录，刷新token不会改变会话的创建时间
+// 个人访问令牌没有会话，必须输入密码
+func recentlyLoggedIn(c *gin.Context) bool {
+	sessionID := c.GetUint("sessionID")
+	if sessionID == 0 {
+		return false
+	}
+	var session models.Session
+	if err := database.DB.First(&session, sessionID).Error; err != nil {
+		return false
+	}
+	return time.Since(session.CreatedAt) < recentLoginWindow
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type DeleteAccountRequest struct {
+	Password   string `json:"password"`    // 不填时要求刚登录过，第三方登录和邮件登录的用户不知道密码
+	TransferTo string `json:"transfer_to"` // 接收卡片的道友用户名，deleted_user_cards 为 transfer 时必填
+}
+
+type AdminDeleteUserRequest struct {
+	TransferTo string `json:"transfer_to"` // 接收卡片的用户名，deleted_user_cards 为 transfer 时必填
+}
+
+// DeleteAccount 注销自己的账号
+func DeleteAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	if err := database.DB.First(&user, c.GetUint("userID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if req.Password != "" {
+		if !user.CheckPassword(req.Password) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "密码错误"})
+			return
+		}
+	} else if !recentlyLoggedIn(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "请输入密码，或重新登录后再注销账号", "reauth_required": true})
+		return
+	}
+	transferTo, ok := cardTransferTarget(c, &user, req.TransferTo, true)
+	if !ok {
+		return
+	}
+	if err := deleteUser(c, &user, transferTo); err != nil {
+		log.Error("注销账号失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注销账号失败"})
+		return
+	}
+	log.Info("用户[%d]注销了账号", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "账号已注销"})
+}
+
+// AdminDeleteUser 管理员删除用户
+func AdminDeleteUser(c *gin.Context) {
+	var req AdminDeleteUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	transferTo, ok := cardTransferTarget(c, user, req.TransferTo, false)
+	if !ok {
+		return
+	}
+	if err := deleteUser(c, user, transferTo); err != nil {
+		log.Error("删除用户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除用户失败"})
+		return
+	}
+	log.Info("用户[%d]删除了用户[%d]", c.GetUint("userID"), user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "用户已删除"})
+}
+
+// cardTransferTarget 卡片按 transfer 处理时，找到接收卡片的用户
+// 用户自己注销时只能转给道友，其他处理方式返回 nil
+func cardTransferTarget(c *gin.Context, user *models.User, username string, friendsOnly bool) (*models.User, bool) {
+	if config.SystemConfig.DeletedUserCards != "transfer" {
+		return nil, true
+	}
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请指定接收卡片的用户", "transfer_required": true})
+		return nil, false
+	}
+	var target models.User
+	if err := database.DB.Where("username = ?", username).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "接收用户不存在"})
+		return nil, false
+	}
+	if target.ID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能把卡片转给要删除的用户"})
+		return nil, false
+	}
+	if friendsOnly {
+		var count int64
+		database.DB.Model(&models.Friends{}).Where("user_id = ? AND friend_id = ?", user.ID, target.ID).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "只能把卡片转给道友"})
+			return nil, false
+		}
+	}
+	return &target, true
+}
+
+// deleteUser 注销用户：匿名化后软删除，解除道友关系，清理登录凭据、待投递的消息，再按配置处理持有的卡片
+// 全部在一个事务中完成，任何一步失败都不会留下已注销但仍能登录或持有卡片的账号
+// 卡片流转记录、动态和通知都保留，其中的昵称改为“已注销用户”
+func deleteUser(c *gin.Context, user *models.User, transferTo *models.User) error {
+	oldUsername := user.Username
+	var afterCommit []func()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// 用户名和邮箱改成占位值，原来的可以重新注册
+		if err := tx.Model(user).UpdateColumns(map[string]any{
+			"username":          fmt.Sprintf("deleted_%d", user.ID),
+			"email":             fmt.Sprintf("deleted_%d@deleted.invalid", user.ID),
+			"nickname":          models.DeletedUserNickname,
+			"password":          "",
+			"email_verified_at": nil,
+			"totp_secret":       "",
+			"totp_enabled_at":   nil,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(user).Error; err != nil {
+			return err
+		}
+		if err := revokeSessions(tx, user.ID, 0, models.SessionRevokedUserDeleted); err != nil {
+			return err
+		}
+		if err := revokeAccessTokens(tx, user.ID); err != nil {
+			return err
+		}
+		// 先取消还没投递的邮件和webhook，再删除webhook配置
+		if err := cancelPendingMessages(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? OR friend_id = ?", user.ID, user.ID).Delete(&models.Friends{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("from_user_id = ? OR to_user_id = ?", user.ID, user.ID).Delete(&models.FriendInvite{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []any{
+			&models.UserIdentity{}, &models.RecoveryCode{}, &models.MagicLinkToken{}, &models.Webhook{},
+			&models.NotificationPreference{}, &models.NotificationSetting{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := anonymizeHistory(tx, user.ID); err != nil {
+			return err
+		}
+		user.Nickname = models.DeletedUserNickname
+		var err error
+		if afterCommit, err = disposeCards(tx, user, transferTo); err != nil {
+			return err
+		}
+		return tx.Create(newUserAudit(c, user.ID, "deleted", oldUsername, "")).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, fn := range afterCommit {
+		fn()
+	}
+	return nil
+}
+
+// cancelPendingMessages 取消发给注销用户、还在发件箱中等待投递的邮件和webhook
+func cancelPendingMessages(tx *gorm.DB, userID uint) error {
+	const reason = "用户已注销，取消投递"
+	if err := tx.Model(&models.OutboxMessage{}).
+		Where("status = ?", models.OutboxStatusPending).
+		Where("notification_id IN (?) OR delivery_id IN (?) OR webhook_id IN (?)",
+			tx.Model(&models.Notification{}).Select("id").Where("user_id = ?", userID),
+			tx.Model(&models.EmailDelivery{}).Select("id").Where("user_id = ?", userID),
+			tx.Model(&models.Webhook{}).Select("id").Where("user_id = ?", userID)).
+		Updates(map[string]any{"status": models.OutboxStatusDead, "last_error": reason}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.EmailDelivery{}).
+		Where("user_id = ? AND status = ?", userID, models.DeliveryStatusPending).
+		Updates(map[string]any{"status": models.DeliveryStatusFailed, "response": reason}).Error
+}
+
+// 动态负载里用户ID与昵称字段的对应关系
+var activityNicknameFields = [][2]string{
+	{"creator_id", "creator_nickname"},
+	{"from_user_id", "from_nickname"},
+	{"to_user_id", "to_nickname"},
+}
+
+// anonymizeHistory 把动态负载和通知里复制的昵称改成“已注销用户”
+func anonymizeHistory(tx *gorm.DB, userID uint) error {
+	var activities []models.Activity
+	if err := tx.Where("user_id = ? OR counterparty_id = ? OR card_id IN (?)", userID, userID,
+		tx.Model(&models.Card{}).Select("id").Where("creator_id = ?", userID)).
+		Find(&activities).Error; err != nil {
+		return err
+	}
+	deleted, _ := json.Marshal(models.DeletedUserNickname)
+	for _, activity := range activities {
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(activity.Payload, &payload); err != nil {
+			continue
+		}
+		changed := false
+		for _, field := range activityNicknameFields {
+			var id uint
+			if json.Unmarshal(payload[field[0]], &id) != nil || id != userID {
+				continue
+			}
+			if _, ok := payload[field[1]]; ok {
+				payload[field[1]] = deleted
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Activity{}).Where("id = ?", activity.ID).Update("payload", json.RawMessage(data)).Error; err != nil {
+			return err
+		}
+	}
+
+	// 通知只在 from_nickname 中保存触发者昵称
+	return tx.Model(&models.Notification{}).Where("from_user_id = ?", userID).
+		Update("from_nickname", models.DeletedUserNickname).Error
+}
+
+// disposeCards 处理注销用户持有的有效卡片，返回事务提交后才发送的动态推送和通知
+// 自己创建还没送出的卡直接过期；别人送的卡按 deleted_user_cards 配置退回创造者、过期或转给指定用户
+func disposeCards(tx *gorm.DB, user *models.User, transferTo *models.User) ([]func(), error) {
+	var cards []models.Card
+	if err := tx.Preload("Creator", models.IncludeDeleted).
+		Where("owner_id = ? AND status = ?", user.ID, models.CardStatusActive).
+		Find(&cards).Error; err != nil {
+		return nil, err
+	}
+
+	policy := config.SystemConfig.DeletedUserCards
+	var afterCommit []func()
+	for i := range cards {
+		card := &cards[i]
+		card.Owner = *user
+		var fn func()
+		var err error
+		switch {
+		case card.CreatorID == user.ID || policy == "expire" ||
+			(policy == "return" && card.Creator.DeletedAt.Valid):
+			fn, err = expireDeletedUserCard(tx, card, user)
+		case policy == "return":
+			fn, err = moveDeletedUserCard(tx, card, user, &card.Creator, "return", "卡片退回通知", "持有你的卡的用户已注销，卡片已退回给你：")
+		default:
+			fn, err = moveDeletedUserCard(tx, card, user, transferTo, "transfer", "卡片转交通知", "已注销的道友把卡片转交给了你：")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("处理卡[%d]失败: %w", card.ID, err)
+		}
+		afterCommit = append(afterCommit, fn)
+	}
+	if len(cards) > 0 {
+		log.Info("已按 %s 处理注销用户[%d]的%d张卡片", policy, user.ID, len(cards))
+	}
+	return afterCommit, nil
+}
+
+// expireDeletedUserCard 让注销用户持有的卡失效，提交后告知创造者
+func expireDeletedUserCard(tx *gorm.DB, card *models.Card, user *models.User) (func(), error) {
+	if err := tx.Model(&models.Card{}).Where("id = ?", card.ID).UpdateColumns(map[string]any{
+		"status":     models.CardStatusExpired,
+		"updated_at": time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+	card.Status = models.CardStatusExpired
+	activities, err := createCardActivities(tx, time.Now(), models.ActivityCardExpire, 0, card, user, &card.Creator)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		publishActivities(activities)
+		if card.CreatorID == user.ID || card.Creator.DeletedAt.Valid {
+			return
+		}
+		notify.Send(&models.Notification{
+			UserID:  card.CreatorID,
+			Type:    models.ActivityCardExpire,
+			Title:   "卡片过期通知",
+			Content: "持有你的卡的用户已注销，卡片已失效：" + models.EscapeNotificationText(card.Title),
+			CardID:  &card.ID,
+		}, nil)
+	}, nil
+}
+
+// moveDeletedUserCard 把注销用户持有的卡交给 to 并记录流转，提交后通知接收者
+func moveDeletedUserCard(tx *gorm.DB, card *models.Card, user, to *models.User, transactionType, title, content string) (func(), error) {
+	if err := tx.Model(&models.Card{}).Where("id = ?", card.ID).UpdateColumns(map[string]any{
+		"owner_id":   to.ID,
+		"updated_at": time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+	card.OwnerID = to.ID
+	if err := tx.Create(&models.CardTransaction{
+		CardID:     card.ID,
+		FromUserID: user.ID,
+		ToUserID:   to.ID,
+		Type:       transactionType,
+	}).Error; err != nil {
+		return nil, err
+	}
+	activities, err := createCardActivities(tx, time.Now(), models.ActivityCardSend, 0, card, user, to)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		publishActivities(activities)
+		notify.Send(&models.Notification{
+			UserID:       to.ID,
+			Type:         models.ActivityCardSend,
+			Title:        title,
+			Content:      content + models.EscapeNotificationText(card.Title),
+			FromUserID:   user.ID,
+			FromNickname: user.Nickname,
+			CardID:       &card.ID,
+		}, &notify.Email{
+			To:       to.Email,
+			Locale:   to.Locale,
+			Template: "card_send",
+			Data:     map[string]any{"FromNickname": user.Nickname, "CardTitle": card.Title},
+		})
+	}, nil
+}
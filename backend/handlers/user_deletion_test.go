@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeleteAccountAnonymizesNotificationSender(t *testing.T) {
+	sender := createTestUser(t, "del_sender")
+	receiver := createTestUser(t, "del_receiver")
+	// 很短的昵称也出现在卡片标题里，注销后标题不能被改动
+	database.DB.Model(sender).Update("nickname", "a")
+
+	w := serve(CreateCard, sender.ID, http.MethodPost, "/api/cards", "/api/cards",
+		gin.H{"title": "a {from} card", "description": "d"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("CreateCard = %d %s", w.Code, w.Body.String())
+	}
+	cardID := uint(decodeJSON(t, w)["card"].(map[string]any)["id"].(float64))
+	w = serve(SendCard, sender.ID, http.MethodPost, fmt.Sprintf("/api/cards/%d/send", cardID), "/api/cards/:id/send",
+		gin.H{"to_username": receiver.Username})
+	if w.Code != http.StatusOK {
+		t.Fatalf("SendCard = %d %s", w.Code, w.Body.String())
+	}
+
+	var notification models.Notification
+	if err := database.DB.Where("user_id = ? AND card_id = ?", receiver.ID, cardID).First(&notification).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := "你收到了来自 a 的卡：a {from} card"; notification.Content != want {
+		t.Fatalf("Content = %q, want %q", notification.Content, want)
+	}
+
+	w = serve(DeleteAccount, sender.ID, http.MethodPost, "/api/account/delete", "/api/account/delete",
+		gin.H{"password": "123456"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("DeleteAccount = %d %s", w.Code, w.Body.String())
+	}
+
+	if err := database.DB.First(&notification, notification.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := "你收到了来自 已注销用户 的卡：a {from} card"; notification.Content != want {
+		t.Errorf("Content = %q, want %q", notification.Content, want)
+	}
+	if notification.FromNickname != models.DeletedUserNickname {
+		t.Errorf("FromNickname = %q", notification.FromNickname)
+	}
+}
+
+func TestDeleteAccountCancelsPendingDeliveries(t *testing.T) {
+	user := createTestUser(t, "del_outbox")
+	webhook := models.Webhook{UserID: user.ID, URL: "https://hooks.example.com/card", Secret: "s", Enabled: true}
+	notification := models.Notification{UserID: user.ID, Title: "t", Content: "c"}
+	session := models.Session{UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	for _, row := range []any{&webhook, &notification, &session} {
+		if err := database.DB.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := []models.OutboxMessage{
+		{Channel: models.ChannelWebhook, WebhookID: &webhook.ID, To: webhook.URL, Status: models.OutboxStatusPending},
+		{Channel: models.ChannelEmail, NotificationID: &notification.ID, To: user.Email, Status: models.OutboxStatusPending},
+	}
+	if err := database.DB.Create(&messages).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(DeleteAccount, user.ID, http.MethodPost, "/api/account/delete", "/api/account/delete",
+		gin.H{"password": "123456"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("DeleteAccount = %d %s", w.Code, w.Body.String())
+	}
+
+	for _, message := range messages {
+		if err := database.DB.First(&message, message.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if message.Status != models.OutboxStatusDead {
+			t.Errorf("%s message status = %q, want %q", message.Channel, message.Status, models.OutboxStatusDead)
+		}
+	}
+	if err := database.DB.First(&session, session.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if session.RevokedAt == nil {
+		t.Error("session was not revoked")
+	}
+	var webhooks int64
+	database.DB.Model(&models.Webhook{}).Where("user_id = ?", user.ID).Count(&webhooks)
+	if webhooks != 0 {
+		t.Errorf("%d webhooks left", webhooks)
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"card-authorization/utils"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 验证链接有效期
+	emailVerifyTTL = 24 * time.Hour
+	// 两次发送验证邮件的最小间隔
+	verificationResendInterval = time.Minute
+)
+
+// sendVerificationEmail 给用户当前的邮箱发送验证链接
+// 链接中带上邮箱地址，修改邮箱后旧链接自动失效
+func sendVerificationEmail(user *models.User) error {
+	now := time.Now()
+	if err := database.DB.Model(user).UpdateColumn("verification_sent_at", now).Error; err != nil {
+		return err
+	}
+	user.VerificationSentAt = &now
+
+	token := utils.SignToken([]byte(config.SystemConfig.SecretKey), "verify_email",
+		fmt.Sprintf("%d:%s", user.ID, user.Email), emailVerifyTTL)
+	return notify.SendAccountEmail(user, "verify_email", map[string]any{
+		"Nickname":  user.Nickname,
+		"Email":     user.Email,
+		"ActionURL": config.SystemConfig.PublicURL + "/api/email/verify?token=" + url.QueryEscape(token),
+	})
+}
+
+// VerifyEmail 邮件中的验证链接，无需登录
+func VerifyEmail(c *gin.Context) {
+	payload, err := utils.VerifyToken([]byte(config.SystemConfig.SecretKey), "verify_email", c.Query("token"))
+	if errors.Is(err, utils.ErrTokenExpired) {
+		messagePage(c, http.StatusBadRequest, "邮箱验证", "验证链接已过期，请登录后重新发送验证邮件。")
+		return
+	}
+	id, email, ok := strings.Cut(payload, ":")
+	userID, parseErr := strconv.ParseUint(id, 10, 64)
+	if err != nil || !ok || parseErr != nil {
+		messagePage(c, http.StatusBadRequest, "邮箱验证", "验证链接无效。")
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil || user.Email != email {
+		// 验证邮件发出后又修改了邮箱
+		messagePage(c, http.StatusBadRequest, "邮箱验证", "验证链接已失效，请登录后重新发送验证邮件。")
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		if err := database.DB.Model(&user).UpdateColumn("email_verified_at", time.Now()).Error; err != nil {
+			log.Error("邮箱验证失败: %v", err)
+			messagePage(c, http.StatusInternalServerError, "邮箱验证", "邮箱验证失败，请稍后重试。")
+			return
+		}
+		log.Info("用户[%d]邮箱验证成功", user.ID)
+	}
+	messagePage(c, http.StatusOK, "邮箱验证", "邮箱验证成功，之后的卡片和道友通知将发送到 "+user.Email+"。")
+}
+
+// ResendVerificationEmail 重新发送验证邮件，每分钟最多一次
+func ResendVerificationEmail(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "邮箱已验证"})
+		return
+	}
+	if user.VerificationSentAt != nil {
+		if wait := time.Until(user.VerificationSentAt.Add(verificationResendInterval)); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "发送太频繁，请稍后再试"})
+			return
+		}
+	}
+	if err := sendVerificationEmail(&user); err != nil {
+		log.Error("发送验证邮件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送验证邮件失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "验证邮件已发送，请查收"})
+}
+
+// BackfillEmailVerification 上线邮箱验证之前注册的用户视为已验证，避免老用户收不到通知
+// 之后注册或修改邮箱的用户都会记录 verification_sent_at，不受影响
+func BackfillEmailVerification() {
+	result := database.DB.Model(&models.User{}).
+		Where("email_verified_at IS NULL AND verification_sent_at IS NULL").
+		UpdateColumn("email_verified_at", time.Now())
+	if result.Error != nil {
+		log.Error("补全邮箱验证状态失败: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Info("已将%d个老用户的邮箱标记为已验证", result.RowsAffected)
+	}
+}
+
+// messagePageTemplate 标题和内容可能包含用户输入（如邮箱地址），交给 html/template 转义
+var messagePageTemplate = template.Must(template.New("message").Parse(`<!DOCTYPE html><html><head><meta charset="UTF-8"><title>{{.Title}}</title></head>` +
+	`<body style="font-family:'Helvetica Neue',Arial,sans-serif;text-align:center;padding:60px 20px;color:#333;">` +
+	`<p>{{.Message}}</p></body></html>`))
+
+// messagePage 邮件链接打开的结果页
+func messagePage(c *gin.Context, status int, title, message string) {
+	var buf bytes.Buffer
+	if err := messagePageTemplate.Execute(&buf, map[string]string{"Title": title, "Message": message}); err != nil {
+		log.Error("渲染结果页失败: %v", err)
+		c.String(http.StatusInternalServerError, "页面渲染失败")
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 每个用户最多注册的webhook数量
+const maxWebhooksPerUser = 10
+
+// 可订阅的事件类型
+var webhookEvents = []models.ActivityType{
+	models.ActivityCardSend,
+	models.ActivityCardUse,
+	models.ActivityCardExpire,
+	models.ActivityCardRevoke,
+	models.ActivityFriendInvite,
+	models.ActivityFriendAccept,
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required,url"`
+	Events []string `json:"events"` // 为空表示订阅全部事件
+}
+
+type UpdateWebhookRequest struct {
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// ListWebhooks 我的webhook
+func ListWebhooks(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var webhooks []models.Webhook
+	if err := database.DB.Where("user_id = ?", userID).Order("id").Find(&webhooks).Error; err != nil {
+		log.Error("获取webhook失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取webhook失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks, "events": webhookEvents})
+}
+
+// CreateWebhook 注册webhook，签名密钥只在创建时返回一次
+func CreateWebhook(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := notify.ValidateWebhookURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, ok := parseWebhookEvents(req.Events)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未知的事件类型"})
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.Webhook{}).Where("user_id = ?", userID).Count(&count)
+	if count >= maxWebhooksPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "webhook数量已达上限"})
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成签名密钥失败"})
+		return
+	}
+	webhook := &models.Webhook{
+		UserID:  userID,
+		URL:     req.URL,
+		Secret:  hex.EncodeToString(secret),
+		Events:  events,
+		Enabled: true,
+	}
+	if err := database.DB.Create(webhook).Error; err != nil {
+		log.Error("创建webhook失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建webhook失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "webhook创建成功，请妥善保存签名密钥",
+		"webhook": webhook,
+		"secret":  webhook.Secret,
+	})
+}
+
+// UpdateWebhook 修改订阅的事件或启停
+func UpdateWebhook(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var req UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var webhook models.Webhook
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&webhook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook不存在"})
+		return
+	}
+
+	updates := map[string]any{}
+	if req.Events != nil {
+		events, ok := parseWebhookEvents(req.Events)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未知的事件类型"})
+			return
+		}
+		updates["events"] = events
+	}
+	if req.Enabled != nil {
+		updates["enabled"] = *req.Enabled
+	}
+	if len(updates) > 0 {
+		if err := database.DB.Model(&webhook).Updates(updates).Error; err != nil {
+			log.Error("更新webhook失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新webhook失败"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook已更新", "webhook": webhook})
+}
+
+// DeleteWebhook 删除webhook
+func DeleteWebhook(c *gin.Context) {
+	userID := c.GetUint("userID")
+	result := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.Webhook{})
+	if result.Error != nil {
+		log.Error("删除webhook失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除webhook失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook已删除"})
+}
+
+// PingWebhook 立即推送一条测试事件
+func PingWebhook(c *gin.Context) {
+	userID := c.GetUint("userID")
+	var webhook models.Webhook
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&webhook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook不存在"})
+		return
+	}
+	// 具体错误只写日志，避免被用来探测对方网络
+	if err := notify.PingWebhook(c.Request.Context(), &webhook); err != nil {
+		log.Warn("webhook[%d]推送测试失败: %v", webhook.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "推送失败，请检查webhook地址是否可以访问"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "推送成功"})
+}
+
+// parseWebhookEvents 校验事件类型并拼成逗号分隔的字符串
+func parseWebhookEvents(events []string) (string, bool) {
+	var result []string
+	for _, e := range events {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !slices.Contains(webhookEvents, models.ActivityType(e)) {
+			return "", false
+		}
+		result = append(result, e)
+	}
+	return strings.Join(result, ","), true
+}
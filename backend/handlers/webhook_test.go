@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"card-authorization/notify"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createWebhook 通过接口注册webhook，返回ID和签名密钥
+func createWebhook(t *testing.T, userID uint, url string) (string, string) {
+	t.Helper()
+	w := serve(CreateWebhook, userID, http.MethodPost, "/api/webhooks", "/api/webhooks", gin.H{"url": url})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("CreateWebhook = %d %s", w.Code, w.Body.String())
+	}
+	result := decodeJSON(t, w)
+	webhook := result["webhook"].(map[string]any)
+	return strconv.Itoa(int(webhook["id"].(float64))), result["secret"].(string)
+}
+
+func TestCreateWebhookRejectsInternalAddress(t *testing.T) {
+	user := createTestUser(t, "hook_internal")
+	for _, url := range []string{"http://10.0.0.1/hook", "http://[::1]:8080/hook", "http://169.254.169.254/", "ftp://example.com/"} {
+		w := serve(CreateWebhook, user.ID, http.MethodPost, "/api/webhooks", "/api/webhooks", gin.H{"url": url})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("CreateWebhook(%s) = %d, want 400", url, w.Code)
+		}
+	}
+}
+
+func TestPingWebhookSignsPayload(t *testing.T) {
+	user := createTestUser(t, "hook_ping")
+
+	var header http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	id, secret := createWebhook(t, user.ID, srv.URL+"/hook")
+	w := serve(PingWebhook, user.ID, http.MethodPost, "/api/webhooks/"+id+"/ping", "/api/webhooks/:id/ping", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PingWebhook = %d %s", w.Code, w.Body.String())
+	}
+
+	var payload notify.WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Event != "ping" {
+		t.Fatalf("payload = %s", body)
+	}
+	if header.Get("X-Card-Event") != "ping" {
+		t.Errorf("X-Card-Event = %q", header.Get("X-Card-Event"))
+	}
+	want := "sha256=" + notify.Sign(secret, header.Get("X-Card-Timestamp"), body)
+	if got := header.Get("X-Card-Signature"); got != want {
+		t.Errorf("X-Card-Signature = %q, want %q", got, want)
+	}
+
+	// 其他用户不能推送
+	other := createTestUser(t, "hook_other")
+	w = serve(PingWebhook, other.ID, http.MethodPost, "/api/webhooks/"+id+"/ping", "/api/webhooks/:id/ping", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("PingWebhook by other user = %d, want 404", w.Code)
+	}
+}
+
+func TestPingWebhookHidesErrorDetails(t *testing.T) {
+	user := createTestUser(t, "hook_fail")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal secret detail", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	id, _ := createWebhook(t, user.ID, srv.URL+"/hook")
+	w := serve(PingWebhook, user.ID, http.MethodPost, "/api/webhooks/"+id+"/ping", "/api/webhooks/:id/ping", nil)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("PingWebhook = %d, want 502", w.Code)
+	}
+	if msg := w.Body.String(); strings.Contains(msg, "500") || strings.Contains(msg, "secret detail") || strings.Contains(msg, "127.0.0.1") {
+		t.Errorf("response leaks details: %s", msg)
+	}
+}
@@ -0,0 +1,212 @@
+// Package mockoidc 本地开发和测试用的 OpenID Connect 身份提供方
+//
+// 不校验密码，在授权页面填写邮箱即可登录；带 login_hint 参数时直接以该邮箱登录，方便脚本和测试使用。
+// 带 unverified=1 时签发的 id_token 中 email_verified 为 false。
+package mockoidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type authCode struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	email       string
+	name        string
+	verified    bool
+	expiresAt   time.Time
+}
+
+// Provider 模拟的身份提供方，Issuer 需和应用配置一致
+type Provider struct {
+	Issuer       string
+	ClientSecret string // 为空时不校验客户端密钥
+
+	key   *rsa.PrivateKey
+	mux   *http.ServeMux
+	mu    sync.Mutex
+	codes map[string]*authCode
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><title>Mock OIDC</title></head>
+<body>
+<h3>Mock OIDC 登录（{{.client_id}}）</h3>
+<form method="post">
+{{range $k, $v := .query}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">
+{{end}}<p>邮箱 <input name="login_hint" type="email" required></p>
+<p>姓名 <input name="name"></p>
+<p><label><input type="checkbox" name="unverified" value="1"> 邮箱未验证</label></p>
+<button type="submit">登录</button>
+</form>
+</body></html>`))
+
+// New 生成签名密钥并注册发现文档、公钥、授权和token接口
+func New(issuer, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	p := &Provider{Issuer: issuer, ClientSecret: clientSecret, key: key, mux: http.NewServeMux(), codes: map[string]*authCode{}}
+	p.mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	p.mux.HandleFunc("/jwks", p.jwks)
+	p.mux.HandleFunc("/authorize", p.authorize)
+	p.mux.HandleFunc("/token", p.token)
+	return p, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "mock",
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	q := r.Form
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "需要 response_type=code 和 S256 PKCE", http.StatusBadRequest)
+		return
+	}
+	if q.Get("login_hint") == "" {
+		query := url.Values{}
+		for k, v := range q {
+			query[k] = v
+		}
+		authorizePage.Execute(w, map[string]any{"client_id": q.Get("client_id"), "query": query})
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = &authCode{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		email:       q.Get("login_hint"),
+		name:        q.Get("name"),
+		verified:    q.Get("unverified") == "",
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "redirect_uri 无效", http.StatusBadRequest)
+		return
+	}
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", q.Get("state"))
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	fail := func(desc string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": desc})
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.Form.Get("client_id"), r.Form.Get("client_secret")
+	}
+	if p.ClientSecret != "" && secret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	code, ok := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code"))
+	p.mu.Unlock()
+	switch {
+	case !ok || time.Now().After(code.expiresAt):
+		fail("code 无效")
+		return
+	case code.clientID != clientID || code.redirectURI != r.Form.Get("redirect_uri"):
+		fail("client_id 或 redirect_uri 不匹配")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		fail("code_verifier 错误")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.Issuer,
+		"sub":            "mock|" + code.email,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          code.nonce,
+		"email":          code.email,
+		"email_verified": code.verified,
+		"name":           code.name,
+	})
+	token.Header["kid"] = "mock"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -6,9 +6,17 @@ import (
 	"card-authorization/handlers"
 	"card-authorization/log"
 	"card-authorization/middleware"
+	"card-authorization/models"
+	"card-authorization/notify"
+	"card-authorization/utils"
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,11 +25,18 @@ import (
 func main() {
 	// 初始化数据库
 	if err := database.InitDB(); err != nil {
-		log.Fatal("数据库初始化失败:", err)
+		log.Fatal("数据库初始化失败: %v", err)
+	}
+	// 带参数运行时执行管理命令后退出
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
 	}
 	//加载外部配置文件
 	if err := config.LoadConfig(); err != nil {
-		log.Fatal("加载外部配置文件失败:", err)
+		log.Fatal("加载外部配置文件失败: %v", err)
+	}
+	if err := middleware.InitJWTKeys(); err != nil {
+		log.Fatal("加载JWT密钥失败: %v", err)
 	}
 
 	// 创建Gin路由
@@ -45,54 +60,181 @@ func main() {
 	r.GET("/cards", handlers.CardsPage)
 	r.GET("/cards/create", handlers.CreateCardPage)
 	r.GET("/friends", handlers.Friends)
+	r.GET("/forgot-password", handlers.ForgotPasswordPage)
+	r.GET("/reset-password", handlers.ResetPasswordPage)
+	r.GET("/magic-login", handlers.MagicLoginPage)
+
+	// 登录token的公钥，其他服务可以据此自行验证token
+	r.GET("/.well-known/jwks.json", handlers.JWKS)
 
 	// API路由组
 	api := r.Group("/api")
 	{
 		// 用户相关(无需鉴权)
-		api.POST("/register", handlers.Register)
+		api.POST("/register", middleware.RateLimit("register"), handlers.Register)
 		api.POST("/login", handlers.Login)
-		api.GET("/test", handlers.Test)
+		api.POST("/token/refresh", handlers.RefreshSession)
+		api.POST("/login/mfa", handlers.LoginMFA)
+		api.GET("/login/methods", handlers.LoginMethods)
+		api.POST("/login/magic", middleware.RateLimit("magic_link"), handlers.RequestMagicLink)
+		api.POST("/login/magic/verify", handlers.MagicLogin)
+		api.GET("/oidc/:provider/login", handlers.OIDCLogin)
+		api.GET("/oidc/:provider/callback", handlers.OIDCCallback)
+		api.GET("/notifications/unsubscribe", handlers.UnsubscribeEmailPage)
+		api.POST("/notifications/unsubscribe", handlers.UnsubscribeEmail)
+		api.GET("/email/verify", handlers.VerifyEmail)
+		api.POST("/password/forgot", middleware.RateLimit("password_forgot"), handlers.ForgotPassword)
+		api.POST("/password/reset", middleware.RateLimit("password_reset"), handlers.ResetPassword)
+		// 实时事件推送，EventSource无法设置请求头，允许通过access_token参数鉴权
+		api.GET("/events/stream", middleware.TokenFromQuery(), middleware.AuthRequired(), handlers.EventStream)
+
+		// 个人访问令牌也可以调用的接口，按权限分组
+		cardsRead := api.Group("/", middleware.ScopedAuth(models.ScopeCardsRead), middleware.RateLimit("api"))
+		{
+			cardsRead.GET("/cards", handlers.GetMyCards)
+			cardsRead.GET("/cards/received", handlers.GetReceivedCards)
+			cardsRead.GET("/cards/send", handlers.GetSendCards)
+			cardsRead.POST("/cards/used", handlers.UsedCard)
+		}
+		cardsWrite := api.Group("/", middleware.ScopedAuth(models.ScopeCardsWrite), middleware.RateLimit("api"))
+		{
+			cardsWrite.POST("/cards", handlers.CreateCard)
+			cardsWrite.POST("/cards/:id/use", handlers.UseCard)
+			cardsWrite.POST("/cards/:id/send", middleware.RateLimit("card_send"), handlers.SendCard)
+			cardsWrite.POST("/cards/:id/delete", handlers.DeleteCard)
+			cardsWrite.GET("/cards/:id/copy", middleware.RateLimit("card_copy"), handlers.CopyCard)
+			cardsWrite.POST("/cards/:id/revoke", handlers.RevokeCard)
+		}
+		friendsRead := api.Group("/", middleware.ScopedAuth(models.ScopeFriendsRead), middleware.RateLimit("api"))
+		{
+			friendsRead.GET("/users/friends", handlers.ListFriends)
+			friendsRead.GET("/users/friends/search", middleware.RateLimit("friend_search"), handlers.SearchFriendUsers)
+			friendsRead.GET("/users/friends/list", handlers.ListFriendUsers)
+			friendsRead.GET("/users/friends/myInvite/list", handlers.ListMyInviteFriends)
+			friendsRead.GET("/users/friends/inviteMy/list", handlers.ListInviteMyFriends)
+		}
+		friendsWrite := api.Group("/", middleware.ScopedAuth(models.ScopeFriendsWrite), middleware.RateLimit("api"))
+		{
+			friendsWrite.GET("/users/friends/:id/invite", middleware.RateLimit("friend_invite"), handlers.InviteFriend)
+			friendsWrite.GET("/users/friends/:id/accept", handlers.AcceptFriend)
+			friendsWrite.GET("/users/friends/:id/delete", handlers.DeleteFriend)
+		}
+		api.GET("/activities", middleware.ScopedAuth(models.ScopeActivitiesRead), middleware.RateLimit("api"), handlers.ListActivities)
+		notificationsRead := api.Group("/", middleware.ScopedAuth(models.ScopeNotificationsRead), middleware.RateLimit("api"))
+		{
+			notificationsRead.GET("/notifications", handlers.ListNotifications)
+			notificationsRead.GET("/notifications/unread_count", handlers.CountUnreadNotifications)
+		}
+		notificationsWrite := api.Group("/", middleware.ScopedAuth(models.ScopeNotificationsWrite), middleware.RateLimit("api"))
+		{
+			notificationsWrite.POST("/notifications/read_all", handlers.ReadAllNotifications)
+			notificationsWrite.POST("/notifications/clear", handlers.ClearReadNotifications)
+			notificationsWrite.POST("/notifications/:id/read", handlers.ReadNotification)
+			notificationsWrite.POST("/notifications/:id/delete", handlers.DeleteNotification)
+		}
+		api.GET("/profile", middleware.ScopedAuth(models.ScopeProfileRead), middleware.RateLimit("api"), handlers.GetProfile)
 
-		// 需要认证的路由
+		// 只能用登录token调用的接口
 		auth := api.Group("/")
-		auth.Use(middleware.AuthRequired())
+		auth.Use(middleware.AuthRequired(), middleware.RateLimit("api"))
 		{
-			// 卡片相关
-			auth.POST("/cards", handlers.CreateCard)
-			auth.GET("/cards", handlers.GetMyCards)
-			auth.GET("/cards/received", handlers.GetReceivedCards)
-			auth.GET("/cards/send", handlers.GetSendCards)
-			auth.POST("/cards/used", handlers.UsedCard)
-			auth.POST("/cards/:id/use", handlers.UseCard)
-			auth.POST("/cards/:id/send", handlers.SendCard)
-			auth.POST("/cards/:id/delete", handlers.DeleteCard)
-			auth.GET("/cards/:id/copy", handlers.CopyCard)
-			// 用户相关
-			auth.GET("/profile", handlers.GetProfile)
-			auth.GET("/users/listUsers", handlers.ListUsers)
+			// 通知设置
+			auth.GET("/notifications/preferences", handlers.GetNotificationPreferences)
+			auth.POST("/notifications/preferences", handlers.UpdateNotificationPreferences)
+			auth.GET("/notifications/deliveries", handlers.ListEmailDeliveries)
+			auth.POST("/email/verify/resend", handlers.ResendVerificationEmail)
+			// 账号和登录
+			auth.POST("/logout", handlers.Logout)
+			auth.POST("/logout/all", handlers.LogoutAll)
+			auth.GET("/account/sessions", handlers.ListSessions)
+			auth.GET("/account/security_events", handlers.ListSecurityEvents)
+			auth.POST("/account/sessions/:id/revoke", handlers.RevokeSession)
+			auth.POST("/account/2fa/setup", handlers.SetupTOTP)
+			auth.POST("/account/2fa/enable", handlers.EnableTOTP)
+			auth.POST("/account/2fa/disable", handlers.DisableTOTP)
+			auth.POST("/account/2fa/recovery_codes", handlers.RegenerateRecoveryCodes)
+			auth.POST("/account/password", handlers.ChangePassword)
+			auth.POST("/account/delete", handlers.DeleteAccount)
+			auth.POST("/account/settings", handlers.UpdateAccountSettings)
 			auth.POST("/user/:id/update", handlers.UpdateUser)
-			auth.GET("/users/lastActive", handlers.LastActive)
-			auth.GET("/users/friends", handlers.ListFriends)
-			auth.GET("/users/friends/search", handlers.SearchFriendUsers)
-			auth.GET("/users/friends/list", handlers.ListFriendUsers)
-			auth.GET("/users/friends/myInvite/list", handlers.ListMyInviteFriends)
-			auth.GET("/users/friends/inviteMy/list", handlers.ListInviteMyFriends)
-			auth.GET("/users/friends/:id/invite", handlers.InviteFriend)
-			auth.GET("/users/friends/:id/accept", handlers.AcceptFriend)
-			auth.GET("/users/friends/:id/delete", handlers.DeleteFriend)
+			// 第三方登录绑定
+			auth.GET("/account/identities", handlers.ListIdentities)
+			auth.POST("/oidc/:provider/link", handlers.LinkIdentity)
+			auth.POST("/account/identities/:id/unlink", handlers.UnlinkIdentity)
+			// 个人访问令牌
+			auth.GET("/account/tokens", handlers.ListAccessTokens)
+			auth.POST("/account/tokens", handlers.CreateAccessToken)
+			auth.POST("/account/tokens/:id/revoke", handlers.RevokeAccessToken)
+			// webhook
+			auth.GET("/webhooks", handlers.ListWebhooks)
+			auth.POST("/webhooks", handlers.CreateWebhook)
+			auth.POST("/webhooks/:id/update", handlers.UpdateWebhook)
+			auth.POST("/webhooks/:id/delete", handlers.DeleteWebhook)
+			auth.POST("/webhooks/:id/ping", handlers.PingWebhook)
+		}
+
+		// 管理员接口
+		// 版主和管理员都能使用的接口
+		moderator := api.Group("/admin")
+		moderator.Use(middleware.AuthRequired(), middleware.RequireRole(models.RoleModerator))
+		{
+			moderator.GET("/users", handlers.AdminListUsers)
+			moderator.GET("/users/:id/audits", handlers.ListUserAudits)
+			moderator.POST("/users/:id/suspend", handlers.SuspendUser)
+			moderator.POST("/users/:id/unsuspend", handlers.UnsuspendUser)
+			moderator.GET("/cards/:id/history", handlers.AdminCardHistory)
+		}
+		admin := api.Group("/admin")
+		admin.Use(middleware.AuthRequired(), middleware.RequireRole(models.RoleAdmin))
+		{
+			admin.GET("/stats", handlers.AdminStats)
+			admin.GET("/outbox", handlers.ListOutbox)
+			admin.POST("/outbox/retry_dead", handlers.RetryDeadOutbox)
+			admin.POST("/outbox/:id/retry", handlers.RetryOutbox)
+			admin.GET("/email/bounces", handlers.ListEmailBounces)
+			admin.POST("/email/bounces/clear", handlers.ClearEmailBounce)
+			admin.POST("/users/:id/update", handlers.AdminUpdateUser)
+			admin.POST("/users/:id/role", handlers.SetUserRole)
+			admin.POST("/users/:id/delete", handlers.AdminDeleteUser)
 		}
 	}
 
+	// 补齐历史动态
+	handlers.BackfillActivities()
+	handlers.BackfillEmailVerification()
+
 	//启动定时器
 	go handlers.CheckExpiredCards()
+	go handlers.SendDigests()
+	// 启动邮件发件箱
+	notify.StartOutbox()
 
 	// 启动服务器
-	log.Info("服务器启动在 http://localhost:" + config.SystemConfig.HTTPPort)
-	if err := r.Run(":" + config.SystemConfig.HTTPPort); err != nil {
-		log.Fatal("服务器启动失败:", err)
+	srv := &http.Server{
+		Addr:    ":" + config.SystemConfig.HTTPPort,
+		Handler: r,
 	}
+	go func() {
+		log.Info("服务器启动在 http://localhost:" + config.SystemConfig.HTTPPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("服务器启动失败: %v", err)
+		}
+	}()
 
+	// 等待退出信号，先断开事件推送连接，再优雅关闭服务器
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Info("服务器正在关闭...")
+	notify.DefaultHub.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error("服务器关闭失败: %v", err)
+	}
+	notify.StopOutbox()
+	utils.CloseMailTransport()
+	log.Info("服务器已关闭")
 }
 
 // customGinLogger 创建自定义日志中间件
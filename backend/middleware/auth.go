@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"card-authorization/config"
 	"card-authorization/database"
 	"card-authorization/models"
+	"card-authorization/utils"
 	"net/http"
 	"strings"
 	"time"
@@ -11,14 +13,24 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("your-secret-key-change-this-in-production")
-
 type Claims struct {
-	UserID uint `json:"user_id"`
+	UserID    uint `json:"user_id"`
+	SessionID uint `json:"sid"`
 	jwt.RegisteredClaims
 }
 
+// AuthRequired 要求登录，只接受登录token
 func AuthRequired() gin.HandlerFunc {
+	return authenticate("")
+}
+
+// ScopedAuth 要求登录，同时接受授予了 scope 权限的个人访问令牌
+func ScopedAuth(scope string) gin.HandlerFunc {
+	return authenticate(scope)
+}
+
+// authenticate scope 为空时不接受个人访问令牌
+func authenticate(scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -35,10 +47,14 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
+		// 个人访问令牌
+		if strings.HasPrefix(parts[1], models.AccessTokenPrefix) {
+			authenticateAccessToken(c, parts[1], scope)
+			return
+		}
+
 		// 解析token
-		token, err := jwt.ParseWithClaims(parts[1], &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		token, err := parseJWT(parts[1], &Claims{})
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证token"})
@@ -61,22 +77,125 @@ func AuthRequired() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if user.SuspendedAt != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": user.SuspensionMessage()})
+			c.Abort()
+			return
+		}
+
+		// 会话注销后，其访问token立即失效
+		var session models.Session
+		if claims.SessionID == 0 || database.DB.First(&session, claims.SessionID).Error != nil ||
+			session.UserID != user.ID || session.RevokedAt != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录"})
+			c.Abort()
+			return
+		}
 
-		// 将用户ID存入上下文
+		touchSession(c, &session)
+
+		// 将用户ID、角色和会话ID存入上下文
 		c.Set("userID", claims.UserID)
+		c.Set("userRole", string(user.Role))
+		c.Set("sessionID", session.ID)
+		c.Next()
+	}
+}
+
+// authenticateAccessToken 校验个人访问令牌和它的权限
+func authenticateAccessToken(c *gin.Context, raw, scope string) {
+	if scope == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "个人访问令牌不能访问该接口"})
+		c.Abort()
+		return
+	}
+
+	var token models.PersonalAccessToken
+	if err := database.DB.Where("token_hash = ?", utils.HashToken(raw)).First(&token).Error; err != nil ||
+		token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "个人访问令牌无效或已过期"})
+		c.Abort()
+		return
+	}
+	if !token.HasScope(scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "个人访问令牌缺少权限: " + scope})
+		c.Abort()
+		return
+	}
+	var user models.User
+	if err := database.DB.First(&user, token.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+		c.Abort()
+		return
+	}
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": user.SuspensionMessage()})
+		c.Abort()
+		return
+	}
+
+	if now := time.Now(); token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastSeenInterval {
+		database.DB.Model(&token).UpdateColumn("last_used_at", now)
+	}
+
+	c.Set("userID", user.ID)
+	c.Set("userRole", string(user.Role))
+	c.Set("accessTokenID", token.ID)
+	c.Next()
+}
+
+// lastSeenInterval 最近访问时间的更新间隔，避免每个请求都写数据库
+const lastSeenInterval = time.Minute
+
+// touchSession 更新会话的最近访问时间和IP
+func touchSession(c *gin.Context, session *models.Session) {
+	now := time.Now()
+	ip := c.ClientIP()
+	if now.Sub(session.LastSeenAt) < lastSeenInterval && session.IP == ip {
+		return
+	}
+	database.DB.Model(session).UpdateColumns(map[string]any{"last_seen_at": now, "ip": ip})
+}
+
+// RequireRole 要求当前用户至少拥有 role 角色，需放在 AuthRequired 之后
+func RequireRole(role models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.Role(c.GetString("userRole")).Includes(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// TokenFromQuery 把 access_token 参数转成 Authorization 头
+// 只用于浏览器 EventSource 这类无法自定义请求头的接口
+func TokenFromQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("access_token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
 		c.Next()
 	}
 }
 
-func GenerateToken(userID uint) (string, error) {
+// AccessTokenTTL 访问token有效期
+func AccessTokenTTL() time.Duration {
+	return time.Duration(config.SystemConfig.JWT.AccessTTLMinutes) * time.Minute
+}
+
+// GenerateToken 为会话签发访问token
+func GenerateToken(userID, sessionID uint) (string, error) {
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL())),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signJWT(claims)
 }
@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"card-authorization/config"
+	"card-authorization/log"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtKey 加载后的签名密钥
+type jwtKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   any // 只用于验证的密钥为 nil
+	verifyKey any
+}
+
+var jwtKeys struct {
+	byKID   map[string]*jwtKey
+	ordered []*jwtKey // 按配置顺序，用于输出JWKS
+	signing *jwtKey
+}
+
+// InitJWTKeys 加载配置中的签名密钥，需在 LoadConfig 之后调用
+// 没有配置任何密钥时由 secret_key 派生一个HS256密钥
+func InitJWTKeys() error {
+	keys := config.SystemConfig.JWT.Keys
+	if len(keys) == 0 {
+		log.Warn("未配置 jwt.keys 或 JWT_SECRET，使用 secret_key 派生的密钥签发登录token")
+		sum := sha256.Sum256([]byte("jwt|" + config.SystemConfig.SecretKey))
+		keys = []config.JWTKey{{KID: "default", Algorithm: "HS256", Secret: base64.RawStdEncoding.EncodeToString(sum[:])}}
+	}
+
+	byKID := map[string]*jwtKey{}
+	var ordered []*jwtKey
+	var signing *jwtKey
+	for _, cfg := range keys {
+		key, err := loadJWTKey(cfg)
+		if err != nil {
+			return fmt.Errorf("加载JWT密钥 %s 失败: %w", cfg.KID, err)
+		}
+		if _, ok := byKID[key.kid]; ok {
+			return fmt.Errorf("JWT密钥 kid 重复: %s", key.kid)
+		}
+		byKID[key.kid] = key
+		ordered = append(ordered, key)
+		if key.signKey == nil {
+			continue
+		}
+		if signing == nil || key.kid == config.SystemConfig.JWT.SigningKID {
+			signing = key
+		}
+	}
+	if signing == nil {
+		return errors.New("没有可以签发token的JWT密钥")
+	}
+	if kid := config.SystemConfig.JWT.SigningKID; kid != "" && signing.kid != kid {
+		return fmt.Errorf("signing_kid %s 不存在或只有公钥", kid)
+	}
+
+	jwtKeys.byKID = byKID
+	jwtKeys.ordered = ordered
+	jwtKeys.signing = signing
+	log.Info("JWT签名密钥: %s (%s)，共%d个验证密钥", signing.kid, signing.method.Alg(), len(byKID))
+	return nil
+}
+
+func loadJWTKey(cfg config.JWTKey) (*jwtKey, error) {
+	if cfg.KID == "" {
+		return nil, errors.New("kid 不能为空")
+	}
+	key := &jwtKey{kid: cfg.KID}
+	switch cfg.Algorithm {
+	case "", "HS256":
+		if len(cfg.Secret) < 32 {
+			return nil, errors.New("HS256 密钥至少32个字符")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(cfg.Secret)
+		key.verifyKey = key.signKey
+		return key, nil
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+	case "EdDSA":
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("不支持的算法 %s", cfg.Algorithm)
+	}
+
+	if cfg.PrivateKeyFile != "" {
+		private, err := readPEM(cfg.PrivateKeyFile, parsePrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("私钥类型错误")
+		}
+		key.signKey = private
+		key.verifyKey = signer.Public()
+	} else if cfg.PublicKeyFile != "" {
+		public, err := readPEM(cfg.PublicKeyFile, x509.ParsePKIXPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.verifyKey = public
+	} else {
+		return nil, errors.New("需要配置 private_key_file 或 public_key_file")
+	}
+
+	// 密钥类型必须和算法一致，防止算法混淆
+	switch key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		if key.method != jwt.SigningMethodRS256 {
+			return nil, errors.New("RSA密钥只能用于RS256")
+		}
+	case ed25519.PublicKey:
+		if key.method != jwt.SigningMethodEdDSA {
+			return nil, errors.New("Ed25519密钥只能用于EdDSA")
+		}
+	default:
+		return nil, errors.New("不支持的密钥类型")
+	}
+	return key, nil
+}
+
+func readPEM(path string, parse func([]byte) (any, error)) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s 不是PEM格式", path)
+	}
+	return parse(block.Bytes)
+}
+
+func parsePrivateKey(der []byte) (any, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+// signJWT 用当前的签名密钥签发token，头部带上 kid
+func signJWT(claims jwt.Claims) (string, error) {
+	key := jwtKeys.signing
+	if key == nil {
+		return "", errors.New("JWT密钥未初始化")
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.signKey)
+}
+
+// parseJWT 按 kid 选择密钥验证token，算法必须和密钥配置一致
+func parseJWT(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := jwtKeys.byKID[kid]
+		if !ok {
+			return nil, fmt.Errorf("未知的kid: %s", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("kid %s 不接受算法 %s", kid, token.Method.Alg())
+		}
+		return key.verifyKey, nil
+	})
+}
+
+// JWK JSON Web Key 中的公钥
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`   // OKP
+}
+
+// PublicJWKs 所有非对称密钥的公钥，供其他服务验证token；HS256 密钥不公开
+func PublicJWKs() []JWK {
+	jwks := []JWK{}
+	for _, key := range jwtKeys.ordered {
+		jwk := JWK{Kid: key.kid, Alg: key.method.Alg(), Use: "sig"}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"card-authorization/config"
+	"card-authorization/log"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitResult 一次取令牌的结果
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int           // 剩余令牌数
+	Reset      time.Duration // 令牌桶补满所需时间
+	RetryAfter time.Duration // 被拒绝时，下一个令牌补充所需时间
+}
+
+// RateLimitStore 保存令牌桶状态，多实例部署时可以换成共享存储的实现
+type RateLimitStore interface {
+	// Take 从 key 对应的令牌桶中取一个令牌
+	Take(key string, policy config.RateLimitPolicy, now time.Time) RateLimitResult
+}
+
+// DefaultRateLimitStore RateLimit 使用的存储，需在注册路由之前替换
+var DefaultRateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+
+// RateLimit 按配置中名为 policy 的令牌桶策略限流
+// 放在 AuthRequired 之后时按用户计数，否则按客户端IP计数；每个策略单独计数
+// 响应中带有 RateLimit-Limit、RateLimit-Remaining、RateLimit-Reset 和 RateLimit-Policy 头
+func RateLimit(policy string) gin.HandlerFunc {
+	p, ok := config.SystemConfig.RateLimits[policy]
+	if !ok {
+		log.Fatal("未配置限流策略: %s", policy)
+	}
+	store := DefaultRateLimitStore
+	policyHeader := fmt.Sprintf("%d;w=%d", p.Burst, p.PeriodSeconds)
+
+	return func(c *gin.Context) {
+		key := policy + ":ip:" + c.ClientIP()
+		if userID := c.GetUint("userID"); userID != 0 {
+			key = policy + ":user:" + strconv.FormatUint(uint64(userID), 10)
+		}
+
+		result := store.Take(key, p, time.Now())
+		c.Header("RateLimit-Limit", strconv.Itoa(p.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		c.Header("RateLimit-Policy", policyHeader)
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "请求太频繁，请稍后再试"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore 保存在进程内存中的令牌桶，重启后清空
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time // 补满的时间，之后可以清理
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, policy config.RateLimitPolicy, now time.Time) RateLimitResult {
+	rate := float64(policy.Requests) / float64(policy.PeriodSeconds) // 每秒补充的令牌数
+	burst := float64(policy.Burst)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 顺便清理已补满的桶，避免无限增长
+	if len(s.buckets) > 10000 {
+		for k, b := range s.buckets {
+			if now.After(b.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, updatedAt: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	b.updatedAt = now
+
+	result := RateLimitResult{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((burst - b.tokens) / rate * float64(time.Second))
+	b.fullAt = now.Add(result.Reset)
+	return result
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// 个人访问令牌的权限范围
+const (
+	ScopeCardsRead          = "cards:read"
+	ScopeCardsWrite         = "cards:write"
+	ScopeFriendsRead        = "friends:read"
+	ScopeFriendsWrite       = "friends:write"
+	ScopeActivitiesRead     = "activities:read"
+	ScopeNotificationsRead  = "notifications:read"
+	ScopeNotificationsWrite = "notifications:write"
+	ScopeProfileRead        = "profile:read"
+)
+
+// AccessTokenScopes 可以授予个人访问令牌的全部权限
+var AccessTokenScopes = []string{
+	ScopeCardsRead,
+	ScopeCardsWrite,
+	ScopeFriendsRead,
+	ScopeFriendsWrite,
+	ScopeActivitiesRead,
+	ScopeNotificationsRead,
+	ScopeNotificationsWrite,
+	ScopeProfileRead,
+}
+
+// AccessTokenPrefix 个人访问令牌的前缀，用来和登录token区分
+const AccessTokenPrefix = "cap_"
+
+// PersonalAccessToken 用户为脚本创建的个人访问令牌，只保存哈希
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	Name       string     `gorm:"not null" json:"name"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	Hint       string     `json:"hint"`   // token的前几位，方便用户辨认
+	Scopes     string     `json:"scopes"` // 逗号分隔
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope 是否授予了某个权限
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	return slices.Contains(strings.Split(t.Scopes, ","), scope)
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ActivityType string
+
+const (
+	ActivityCardCreate   ActivityType = "card_create"   // 创建卡片
+	ActivityCardSend     ActivityType = "card_send"     // 发送卡片
+	ActivityCardUse      ActivityType = "card_use"      // 使用卡片
+	ActivityCardExpire   ActivityType = "card_expire"   // 卡片过期
+	ActivityCardRevoke   ActivityType = "card_revoke"   // 收回卡片
+	ActivityFriendInvite ActivityType = "friend_invite" // 发起道友邀请
+	ActivityFriendAccept ActivityType = "friend_accept" // 接受道友邀请
+)
+
+// Activity 用户动态，每个参与者各写一条，便于按用户分页和过滤
+type Activity struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	UserID         uint            `gorm:"index;not null" json:"user_id"` // 动态所属用户
+	ActorID        uint            `json:"actor_id"`                      // 触发者，系统触发时为0
+	CounterpartyID uint            `gorm:"index" json:"counterparty_id"`  // 对方用户，没有时为0
+	Type           ActivityType    `gorm:"index;not null" json:"type"`
+	CardID         *uint           `json:"card_id,omitempty"`
+	Payload        json.RawMessage `gorm:"type:text" json:"payload"` // CardActivityPayload 或 FriendActivityPayload
+	CreatedAt      time.Time       `gorm:"index" json:"created_at"`
+}
+
+// CardActivityPayload 卡片类动态的负载
+type CardActivityPayload struct {
+	CardID          uint   `json:"card_id"`
+	CardTitle       string `json:"card_title"`
+	CardDescription string `json:"card_description"`
+	CreatorID       uint   `json:"creator_id"`
+	CreatorNickname string `json:"creator_nickname"`
+	FromUserID      uint   `json:"from_user_id"`
+	FromNickname    string `json:"from_nickname"`
+	ToUserID        uint   `json:"to_user_id"`
+	ToNickname      string `json:"to_nickname"`
+}
+
+// FriendActivityPayload 道友类动态的负载
+type FriendActivityPayload struct {
+	FromUserID   uint   `json:"from_user_id"`
+	FromNickname string `json:"from_nickname"`
+	ToUserID     uint   `json:"to_user_id"`
+	ToNickname   string `json:"to_nickname"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// UserAudit 用户资料的修改记录，每个字段一条
+type UserAudit struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"` // 被修改的用户
+	ActorID   uint      `gorm:"not null" json:"actor_id"`      // 操作人，本人修改时与 UserID 相同
+	Field     string    `gorm:"not null" json:"field"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
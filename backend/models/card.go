@@ -24,6 +24,7 @@ type Card struct {
 	UpdatedAt       time.Time  `json:"updated_at"`
 	TransactionAt   *time.Time `json:"transaction_at,omitempty"`   // 交易时间
 	TransactionType string     `json:"transaction_type,omitempty"` // 交易类型
+	RemindedAt      *time.Time `json:"-"`                          // 过期提醒发送时间
 
 	// 关联
 	Creator User `gorm:"foreignKey:CreatorID" json:"creator,omitempty"`
@@ -35,7 +36,7 @@ type CardTransaction struct {
 	CardID     uint      `gorm:"not null" json:"card_id"`
 	FromUserID uint      `gorm:"not null" json:"from_user_id"`
 	ToUserID   uint      `gorm:"not null" json:"to_user_id"`
-	Type       string    `gorm:"not null" json:"type"` // send, use
+	Type       string    `gorm:"not null" json:"type"` // send, use, revoke，注销账号时的 return, transfer
 	CreatedAt  time.Time `json:"created_at"`
 
 	// 关联
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	DeliveryStatusPending    = "pending"    // 等待投递（含等待重试）
+	DeliveryStatusSent       = "sent"       // 服务器已接收
+	DeliveryStatusFailed     = "failed"     // 最终投递失败
+	DeliveryStatusSuppressed = "suppressed" // 收件地址已被标记为无法投递，没有发送
+)
+
+// EmailDelivery 一封邮件的投递记录
+type EmailDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	UserID         uint       `gorm:"index" json:"user_id"`                   // 收件用户
+	NotificationID *uint      `gorm:"index" json:"notification_id,omitempty"` // 摘要邮件没有对应的通知
+	CardID         *uint      `gorm:"index" json:"card_id,omitempty"`
+	Recipient      string     `gorm:"index;not null" json:"recipient"`
+	Template       string     `json:"template"`
+	Status         string     `gorm:"index;not null" json:"status"`
+	Response       string     `json:"response"` // 最近一次SMTP响应或错误
+	Attempts       int        `json:"attempts"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// EmailBounce 收件地址的退信统计，连续被服务器拒收达到阈值后标记为无法投递
+type EmailBounce struct {
+	ID                  uint       `gorm:"primaryKey" json:"id"`
+	Address             string     `gorm:"uniqueIndex;not null" json:"address"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error"`
+	UndeliverableAt     *time.Time `json:"undeliverable_at"` // 不为空时不再向该地址发送邮件
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
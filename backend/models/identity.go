@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity 用户绑定的第三方登录身份
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Provider  string    `gorm:"uniqueIndex:idx_identity_subject;not null" json:"provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_identity_subject;not null" json:"-"` // ID token 中的 sub
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// MagicLinkToken 邮件登录链接的一次性token，只保存哈希
+type MagicLinkToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"` // 已使用或被新的链接取代
+	IP        string     `json:"ip"`      // 申请登录链接的客户端IP
+	CreatedAt time.Time  `json:"created_at"`
+}
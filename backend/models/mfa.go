@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// RecoveryCode 两步验证的一次性恢复码，只保存哈希
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EmailStatusNone    = ""        // 未通过邮件投递
+	EmailStatusPending = "pending" // 等待投递
+	EmailStatusSent    = "sent"    // 投递成功
+	EmailStatusFailed  = "failed"  // 投递失败
+	EmailStatusDigest  = "digest"  // 用户开启了摘要，合并到每日/每周摘要邮件中
+)
+
+// Notification 站内通知，每个卡片和道友事件都会给接收方写一条
+type Notification struct {
+	ID           uint         `gorm:"primaryKey" json:"id"`
+	UserID       uint         `gorm:"index;not null" json:"user_id"` // 接收通知的用户
+	Type         ActivityType `gorm:"not null" json:"type"`          // 与动态类型一致
+	Title        string       `gorm:"not null" json:"title"`
+	Content      string       `json:"content"`
+	FromUserID   uint         `json:"from_user_id"`  // 触发通知的用户，系统触发时为0
+	FromNickname string       `json:"from_nickname"` // 触发者昵称，标题和内容中以 SenderPlaceholder 引用
+	CardID       *uint        `json:"card_id,omitempty"`
+	EmailStatus  string       `json:"email_status"`
+	ReadAt       *time.Time   `gorm:"index" json:"read_at"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// SenderPlaceholder 通知标题和内容中触发者昵称的位置，读取时替换为 FromNickname
+const SenderPlaceholder = "{from}"
+
+// EscapeNotificationText 转义拼入通知的卡片标题等用户输入，其中的 { 不会被当成占位符
+func EscapeNotificationText(s string) string {
+	return strings.ReplaceAll(s, "{", "{{")
+}
+
+// Render 把标题和内容中的占位符替换为触发者昵称，只能对保存的原文调用一次
+func (n *Notification) Render() {
+	r := strings.NewReplacer("{{", "{", SenderPlaceholder, n.FromNickname)
+	n.Title = r.Replace(n.Title)
+	n.Content = r.Replace(n.Content)
+}
+
+// AfterFind 从数据库读出的通知直接返回替换后的文本
+func (n *Notification) AfterFind(tx *gorm.DB) error {
+	n.Render()
+	return nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	OutboxStatusPending = "pending" // 等待投递（含等待重试）
+	OutboxStatusSending = "sending" // 投递中
+	OutboxStatusSent    = "sent"    // 投递成功
+	OutboxStatusDead    = "dead"    // 超过最大尝试次数，进入死信
+)
+
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// OutboxMessage 发件箱，处理器只负责入队，由后台协程按渠道投递
+type OutboxMessage struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	NotificationID *uint      `gorm:"index" json:"notification_id,omitempty"` // 对应的站内通知
+	Channel        string     `gorm:"not null;default:email" json:"channel"`
+	WebhookID      *uint      `json:"webhook_id,omitempty"`  // webhook渠道对应的配置，签名密钥投递时再读取
+	DeliveryID     *uint      `json:"delivery_id,omitempty"` // 邮件渠道对应的投递记录
+	To             string     `gorm:"not null" json:"to"`    // 邮箱地址或webhook URL
+	Subject        string     `json:"subject"`
+	Body           string     `gorm:"type:text" json:"-"`
+	TextBody       string     `gorm:"type:text" json:"-"` // 邮件的纯文本版本
+	UnsubscribeURL string     `json:"-"`                  // 通知邮件的一键退订地址，账号类邮件为空
+	Status         string     `gorm:"index;not null" json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"index" json:"next_attempt_at"`
+	LastError      string     `json:"last_error"`
+	SentAt         *time.Time `json:"sent_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
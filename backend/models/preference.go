@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// NotificationCardExpiring 卡片即将过期提醒，只产生通知不记录动态
+const NotificationCardExpiring ActivityType = "card_expiring"
+
+const (
+	DigestModeOff    = "off"    // 逐条发送邮件
+	DigestModeDaily  = "daily"  // 每日摘要
+	DigestModeWeekly = "weekly" // 每周摘要
+)
+
+// NotificationPreference 用户对某类事件启用的通知渠道，没有记录时全部启用
+type NotificationPreference struct {
+	ID      uint         `gorm:"primaryKey" json:"-"`
+	UserID  uint         `gorm:"uniqueIndex:idx_preference_user_event;not null" json:"-"`
+	Event   ActivityType `gorm:"uniqueIndex:idx_preference_user_event;not null" json:"event"`
+	Email   bool         `json:"email"`
+	InApp   bool         `json:"in_app"`
+	Webhook bool         `json:"webhook"`
+}
+
+// NotificationSetting 用户的通知全局设置
+type NotificationSetting struct {
+	ID         uint   `gorm:"primaryKey" json:"-"`
+	UserID     uint   `gorm:"uniqueIndex;not null" json:"-"`
+	Timezone   string `json:"timezone"`                       // IANA时区，如 Asia/Shanghai
+	QuietStart string `json:"quiet_start"`                    // 免打扰开始时间 HH:MM，为空表示不启用
+	QuietEnd   string `json:"quiet_end"`                      // 免打扰结束时间 HH:MM，可以跨天
+	DigestMode string `gorm:"default:off" json:"digest_mode"` // off、daily、weekly
+	// 上次摘要覆盖到的时间，下一封摘要从这里开始统计
+	LastDigestAt *time.Time `json:"last_digest_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
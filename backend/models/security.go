@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// SecurityEventType 安全事件类型
+type SecurityEventType string
+
+const (
+	SecurityLoginSuccess  SecurityEventType = "login_success"
+	SecurityLoginFailed   SecurityEventType = "login_failed"
+	SecurityAccountLocked SecurityEventType = "account_locked"
+)
+
+// SecurityEvent 登录相关的安全事件，也是登录限流的计数来源
+// 用户名不存在时 UserID 为空，仍按用户名记录
+type SecurityEvent struct {
+	ID        uint              `gorm:"primaryKey" json:"id"`
+	UserID    *uint             `gorm:"index" json:"user_id"`
+	Username  string            `gorm:"index" json:"username"`
+	IP        string            `gorm:"index" json:"ip"`
+	Type      SecurityEventType `gorm:"index" json:"type"`
+	Detail    string            `json:"detail"` // 失败原因，如 password、mfa
+	UserAgent string            `json:"user_agent"`
+	CreatedAt time.Time         `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// 会话注销原因
+const (
+	SessionRevokedLogout         = "logout"
+	SessionRevokedByUser         = "revoked"
+	SessionRevokedLogoutAll      = "logout_all"
+	SessionRevokedPasswordChange = "password_changed"
+	SessionRevokedPasswordReset  = "password_reset"
+	SessionRevokedTokenReuse     = "refresh_token_reuse"
+	SessionRevokedSuspended      = "suspended"
+	SessionRevokedUserDeleted    = "user_deleted"
+)
+
+// Session 一次登录，刷新token轮换时始终属于同一个会话
+type Session struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	UserID        uint       `gorm:"index;not null" json:"user_id"`
+	UserAgent     string     `json:"user_agent"`
+	Device        string     `json:"device"` // 从 User-Agent 识别的浏览器和系统，如 Chrome / Windows
+	IP            string     `json:"ip"`     // 最近一次访问的IP
+	LastSeenAt    time.Time  `json:"last_seen_at"`
+	ExpiresAt     time.Time  `json:"expires_at"` // 最近一次刷新后顺延
+	RevokedAt     *time.Time `json:"revoked_at"`
+	RevokedReason string     `json:"revoked_reason"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// RefreshToken 会话签发过的刷新token，只保存哈希
+// 用过的token保留到会话过期，再次出现说明token已泄露
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SessionID uint       `gorm:"index;not null" json:"session_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -7,14 +7,66 @@ import (
 	"gorm.io/gorm"
 )
 
+// Role 用户角色，权限从低到高
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator" // 可以查看用户和卡片记录，停用普通用户
+	RoleAdmin     Role = "admin"     // 全部管理权限，包括修改角色和删除用户
+)
+
+var roleLevels = map[Role]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+// Valid 是否是已定义的角色
+func (r Role) Valid() bool {
+	_, ok := roleLevels[r]
+	return ok
+}
+
+// Includes 是否拥有 role 的全部权限
+func (r Role) Includes(role Role) bool {
+	return r.Valid() && roleLevels[r] >= roleLevels[role]
+}
+
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Username  string    `gorm:"unique;not null" json:"username"`
-	Email     string    `gorm:"unique;not null" json:"email"`
-	Password  string    `gorm:"not null" json:"-"`
-	Nickname  string    `json:"nickname"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Username string `gorm:"unique;not null" json:"username"`
+	Email    string `gorm:"unique;not null" json:"email"`
+	Password string `gorm:"not null" json:"-"`
+	Nickname string `json:"nickname"`
+	Locale   string `gorm:"default:zh-CN" json:"locale"` // 邮件语言：zh-CN 或 en
+	Role     Role   `gorm:"default:user;not null;index" json:"role"`
+	// 邮箱验证时间，为空时不发送通知邮件
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+	VerificationSentAt *time.Time `json:"-"` // 最近一次发送验证邮件的时间，用于限制重发频率
+	// 两步验证，TOTPSecret 不为空而 TOTPEnabledAt 为空时表示正在绑定
+	TOTPSecret    string     `json:"-"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at"`
+	TOTPLastStep  int64      `json:"-"` // 最近一次使用的验证码时间窗口，防止验证码被重放
+	// 被管理员停用的时间和原因，停用期间无法登录和调用接口
+	SuspendedAt     *time.Time `json:"suspended_at"`
+	SuspendedReason string     `json:"suspended_reason,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	// 注销时间，注销时用户名、邮箱和昵称会被匿名化，历史记录中显示为“已注销用户”
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
+
+// DeletedUserNickname 已注销用户在历史记录中显示的昵称
+const DeletedUserNickname = "已注销用户"
+
+// IncludeDeleted 关联查询时包含已注销的用户，如 Preload("Creator", models.IncludeDeleted)
+func IncludeDeleted(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// SuspensionMessage 账号被停用时返回给用户的提示
+func (u *User) SuspensionMessage() string {
+	if u.SuspendedReason == "" {
+		return "账号已被停用"
+	}
+	return "账号已被停用：" + u.SuspendedReason
 }
 
 type Friends struct {
@@ -35,14 +87,23 @@ type FriendInvite struct {
 
 // 创建用户前加密密码
 func (u *User) BeforeCreate(tx *gorm.DB) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hashedPassword, err := HashPassword(u.Password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 	return nil
 }
 
+// HashPassword 用bcrypt加密密码，修改密码时 BeforeCreate 不会触发，需要手动调用
+func HashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
 // 验证密码
 func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
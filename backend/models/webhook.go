@@ -0,0 +1,27 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Webhook 用户注册的事件回调地址
+type Webhook struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	URL       string    `gorm:"not null" json:"url"`
+	Secret    string    `gorm:"not null" json:"-"` // HMAC签名密钥，只在创建时返回一次
+	Events    string    `json:"events"`            // 订阅的事件类型，逗号分隔，为空表示全部
+	Enabled   bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscribes 是否订阅了某类事件
+func (w *Webhook) Subscribes(eventType ActivityType) bool {
+	if w.Events == "" {
+		return true
+	}
+	return slices.Contains(strings.Split(w.Events, ","), string(eventType))
+}
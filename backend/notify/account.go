@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/models"
+)
+
+// EmailConfigured 是否配置了可用的邮件投递方式，没有时不提供依赖邮件的功能（如邮件登录）
+func EmailConfigured() bool {
+	cfg := config.SystemConfig.EmailConfig
+	return config.SystemConfig.Notifier == "log" || cfg.Transport == "file" || cfg.SMTPHost != ""
+}
+
+// SendAccountEmail 发送验证邮箱、重置密码等账号邮件
+// 账号邮件不受通知偏好、免打扰和邮箱验证状态的限制，也没有退订链接
+func SendAccountEmail(user *models.User, template string, data map[string]any) error {
+	rendered, err := RenderEmail(template, user.Locale, data)
+	if err != nil {
+		return err
+	}
+	return enqueueDelivery(&models.EmailDelivery{
+		UserID:    user.ID,
+		Recipient: user.Email,
+		Template:  template,
+	}, &models.OutboxMessage{
+		Channel:  models.ChannelEmail,
+		To:       user.Email,
+		Subject:  rendered.Subject,
+		Body:     rendered.HTML,
+		TextBody: rendered.Text,
+	})
+}
+
+// EmailVerified 用户的邮箱是否已验证，未验证的邮箱不发送通知邮件
+func EmailVerified(userID uint) bool {
+	var count int64
+	database.DB.Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NOT NULL", userID).
+		Count(&count)
+	return count > 0
+}
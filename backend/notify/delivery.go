@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/utils"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Undeliverable 收件地址是否已被标记为无法投递
+func Undeliverable(address string) bool {
+	var count int64
+	database.DB.Model(&models.EmailBounce{}).
+		Where("address = ? AND undeliverable_at IS NOT NULL", normalizeAddress(address)).
+		Count(&count)
+	return count > 0
+}
+
+// ClearUndeliverable 解除无法投递标记，用户修改邮箱或管理员确认地址恢复后调用
+func ClearUndeliverable(address string) (bool, error) {
+	result := database.DB.Where("address = ?", normalizeAddress(address)).Delete(&models.EmailBounce{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// enqueueDelivery 先写投递记录再入队，投递协程通过 DeliveryID 回写结果
+// 收件地址已被标记为无法投递时只留下 suppressed 记录，返回错误
+func enqueueDelivery(delivery *models.EmailDelivery, msg *models.OutboxMessage) error {
+	if Undeliverable(delivery.Recipient) {
+		delivery.Status = models.DeliveryStatusSuppressed
+		delivery.Response = "收件地址已被标记为无法投递"
+		database.DB.Create(delivery)
+		return errors.New(delivery.Response)
+	}
+	delivery.Status = models.DeliveryStatusPending
+	if err := database.DB.Create(delivery).Error; err != nil {
+		return err
+	}
+	msg.DeliveryID = &delivery.ID
+	if err := Enqueue(msg); err != nil {
+		database.DB.Model(delivery).Updates(map[string]any{
+			"status":   models.DeliveryStatusFailed,
+			"response": err.Error(),
+		})
+		return err
+	}
+	return nil
+}
+
+// recordDelivery 回写一次投递尝试的结果，final 表示不会再重试
+func recordDelivery(msg *models.OutboxMessage, resp string, err error, final bool) {
+	if msg.DeliveryID == nil {
+		return
+	}
+	now := time.Now()
+	updates := map[string]any{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"last_attempt_at": now,
+		"response":        resp,
+	}
+	switch {
+	case err == nil:
+		updates["status"] = models.DeliveryStatusSent
+		updates["delivered_at"] = now
+	case final:
+		updates["status"] = models.DeliveryStatusFailed
+		updates["response"] = err.Error()
+	default:
+		updates["status"] = models.DeliveryStatusPending
+		updates["response"] = err.Error()
+	}
+	if err := database.DB.Model(&models.EmailDelivery{}).Where("id = ?", *msg.DeliveryID).Updates(updates).Error; err != nil {
+		log.Error("更新投递记录[%d]失败: %v", *msg.DeliveryID, err)
+	}
+}
+
+// permanentRejection 服务器以5xx拒绝了收件人，重试也不会成功
+func permanentRejection(err error) bool {
+	var rcptErr *utils.RecipientError
+	return errors.As(err, &rcptErr) && rcptErr.Permanent()
+}
+
+// recordBounce 统计收件地址被拒收的次数，只统计收件人被永久拒绝的情况，
+// 服务器宕机、认证失败等与地址无关的错误不计入
+func recordBounce(address string, err error) {
+	address = normalizeAddress(address)
+	bounce := models.EmailBounce{Address: address, ConsecutiveFailures: 1, LastError: err.Error()}
+	if dbErr := database.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "address"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"consecutive_failures": gorm.Expr("consecutive_failures + 1"),
+			"last_error":           bounce.LastError,
+			"updated_at":           time.Now(),
+		}),
+	}).Create(&bounce).Error; dbErr != nil {
+		log.Error("记录退信失败: %v", dbErr)
+		return
+	}
+	result := database.DB.Model(&models.EmailBounce{}).
+		Where("address = ? AND undeliverable_at IS NULL AND consecutive_failures >= ?", address, config.SystemConfig.EmailConfig.UndeliverableAfter).
+		Update("undeliverable_at", time.Now())
+	if result.RowsAffected > 0 {
+		log.Warn("邮箱 %s 连续被拒收，已标记为无法投递: %v", address, err)
+	}
+}
+
+// recordDelivered 投递成功后清零连续失败次数
+func recordDelivered(address string) {
+	database.DB.Model(&models.EmailBounce{}).
+		Where("address = ? AND undeliverable_at IS NULL AND consecutive_failures > 0", normalizeAddress(address)).
+		Update("consecutive_failures", 0)
+}
+
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"card-authorization/database"
+	"card-authorization/models"
+	"slices"
+	"time"
+)
+
+// 摘要邮件在用户时区的发送时间，每周摘要在周一发送
+const digestHour = 8
+
+// digestEvent 摘要邮件退订链接中使用的事件名
+const digestEvent models.ActivityType = "digest"
+
+// DigestEvents 摘要涵盖的事件，开启摘要后这些事件不再单独发邮件
+var DigestEvents = []models.ActivityType{
+	models.ActivityCardSend,
+	models.ActivityCardUse,
+	models.ActivityFriendInvite,
+	models.NotificationCardExpiring,
+}
+
+// DigestItem 摘要中的一条记录
+type DigestItem struct {
+	Nickname  string // 对方昵称
+	CardTitle string // 卡片标题，道友邀请为空
+	Time      string // 按用户时区格式化的时间
+}
+
+// ValidDigestMode 校验摘要模式
+func ValidDigestMode(mode string) bool {
+	return mode == models.DigestModeOff || mode == models.DigestModeDaily || mode == models.DigestModeWeekly
+}
+
+// CoveredByDigest 该事件的邮件是否合并到摘要中
+func CoveredByDigest(setting *models.NotificationSetting, event models.ActivityType) bool {
+	if setting.DigestMode != models.DigestModeDaily && setting.DigestMode != models.DigestModeWeekly {
+		return false
+	}
+	return slices.Contains(DigestEvents, event)
+}
+
+// DigestPeriod 摘要的统计周期
+func DigestPeriod(mode string) time.Duration {
+	if mode == models.DigestModeWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// DigestDueAt 不晚于 now 的最近一次摘要发送时间，摘要关闭时返回零值
+// 上次摘要早于该时间时就应该发送新的摘要
+func DigestDueAt(setting *models.NotificationSetting, now time.Time) time.Time {
+	if setting.DigestMode != models.DigestModeDaily && setting.DigestMode != models.DigestModeWeekly {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(setting.Timezone)
+	if err != nil {
+		loc, _ = time.LoadLocation(DefaultTimezone)
+	}
+	local := now.In(loc)
+	due := time.Date(local.Year(), local.Month(), local.Day(), digestHour, 0, 0, 0, loc)
+	if due.After(local) {
+		due = due.AddDate(0, 0, -1)
+	}
+	if setting.DigestMode == models.DigestModeWeekly {
+		// 回退到周一
+		due = due.AddDate(0, 0, -((int(due.Weekday()) + 6) % 7))
+	}
+	return due.Local()
+}
+
+// SendDigest 渲染摘要邮件并放入发件箱，处于免打扰时段时推迟投递
+func SendDigest(user *models.User, setting *models.NotificationSetting, data map[string]any) error {
+	unsubscribeURL := UnsubscribeURL(user.ID, digestEvent)
+	values := map[string]any{
+		"DigestMode":     setting.DigestMode,
+		"UnsubscribeURL": unsubscribeURL,
+	}
+	for k, v := range data {
+		values[k] = v
+	}
+	rendered, err := RenderEmail("digest", user.Locale, values)
+	if err != nil {
+		return err
+	}
+	return enqueueDelivery(&models.EmailDelivery{
+		UserID:    user.ID,
+		Recipient: user.Email,
+		Template:  "digest",
+	}, &models.OutboxMessage{
+		Channel:        models.ChannelEmail,
+		To:             user.Email,
+		Subject:        rendered.Subject,
+		Body:           rendered.HTML,
+		TextBody:       rendered.Text,
+		UnsubscribeURL: unsubscribeURL,
+		NextAttemptAt:  QuietUntil(setting, time.Now()),
+	})
+}
+
+// MarkDigestSent 记录摘要覆盖到的时间
+func MarkDigestSent(userID uint, at time.Time) error {
+	return database.DB.Model(&models.NotificationSetting{}).
+		Where("user_id = ?", userID).
+		Update("last_digest_at", at).Error
+}
@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"sync"
+)
+
+// 每个订阅者的缓冲区大小，写满说明客户端消费太慢，直接断开让它带着 Last-Event-ID 重连补发
+const subscriberBuffer = 64
+
+// Event 推送给客户端的事件
+type Event struct {
+	ID   uint   // 事件ID，客户端重连时通过 Last-Event-ID 带回
+	Type string // 事件类型
+	Data any    // 事件内容，按JSON输出
+}
+
+// Subscriber 一个连接上的订阅
+type Subscriber struct {
+	UserID uint
+	C      <-chan Event
+
+	ch     chan Event
+	closed bool
+}
+
+// Hub 进程内按用户ID分发事件的发布订阅中心
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[uint]map[*Subscriber]struct{}
+	closed bool
+}
+
+// DefaultHub 全局事件中心
+var DefaultHub = NewHub()
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[uint]map[*Subscriber]struct{})}
+}
+
+// Subscribe 订阅某个用户的事件，Hub已关闭时返回的订阅会立即结束
+func (h *Hub) Subscribe(userID uint) *Subscriber {
+	ch := make(chan Event, subscriberBuffer)
+	s := &Subscriber{UserID: userID, C: ch, ch: ch}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		s.closed = true
+		close(ch)
+		return s
+	}
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*Subscriber]struct{})
+	}
+	h.subs[userID][s] = struct{}{}
+	return s
+}
+
+// Unsubscribe 取消订阅，可重复调用
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+// Publish 向某个用户的所有连接推送事件，不会阻塞
+func (h *Hub) Publish(userID uint, e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs[userID] {
+		select {
+		case s.ch <- e:
+		default:
+			// 消费太慢，断开后由客户端重连补发
+			h.remove(s)
+		}
+	}
+}
+
+// Close 关闭所有订阅，用于服务退出
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for s := range subs {
+			h.remove(s)
+		}
+	}
+}
+
+func (h *Hub) remove(s *Subscriber) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.ch)
+	if subs := h.subs[s.UserID]; subs != nil {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(h.subs, s.UserID)
+		}
+	}
+}
+
+// Publish 向全局事件中心推送事件
+func Publish(userID uint, e Event) {
+	DefaultHub.Publish(userID, e)
+}
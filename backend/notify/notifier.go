@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"bytes"
+	"card-authorization/config"
+	"card-authorization/log"
+	"card-authorization/models"
+	"card-authorization/utils"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Message 一次投递的内容
+type Message struct {
+	Channel string
+	To      string // 邮箱地址或webhook URL
+	Subject string
+	Body    string // 邮件为HTML，webhook为JSON
+	Text    string // 邮件的纯文本版本
+	Event   string // 事件类型
+	Secret  string // webhook签名密钥
+
+	UnsubscribeURL string // 邮件的一键退订地址，为空时不附带 List-Unsubscribe 头
+}
+
+// Notifier 通知投递渠道，返回对方的响应，记录在投递记录中
+type Notifier interface {
+	Notify(ctx context.Context, msg *Message) (string, error)
+}
+
+// SMTPNotifier 通过SMTP发送邮件
+type SMTPNotifier struct{}
+
+func (SMTPNotifier) Notify(ctx context.Context, msg *Message) (string, error) {
+	return utils.SendMultipartEmail(ctx, msg.To, msg.Subject, msg.Body, msg.Text, msg.UnsubscribeURL)
+}
+
+// LogNotifier 只写日志，开发环境使用
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, msg *Message) (string, error) {
+	body := msg.Body
+	if msg.Text != "" {
+		body = msg.Text
+	}
+	log.Info("[%s] to=%s subject=%s event=%s\n%s", msg.Channel, msg.To, msg.Subject, msg.Event, body)
+	return "logged", nil
+}
+
+// WebhookNotifier 把JSON以POST方式推送到用户配置的地址，并附带HMAC签名
+// Client 为空时使用 defaultWebhookClient，拒绝连接内网地址且不跟随重定向
+//
+// 签名方式：X-Card-Signature = "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body))，
+// timestamp 为 X-Card-Timestamp 头中的Unix秒，接收方应同时校验时间戳防止重放
+type WebhookNotifier struct {
+	Client *http.Client
+}
+
+func (w WebhookNotifier) Notify(ctx context.Context, msg *Message) (string, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.To, bytes.NewBufferString(msg.Body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "card-authorization-webhook")
+	req.Header.Set("X-Card-Event", msg.Event)
+	req.Header.Set("X-Card-Timestamp", timestamp)
+	req.Header.Set("X-Card-Signature", "sha256="+Sign(msg.Secret, timestamp, []byte(msg.Body)))
+
+	client := w.Client
+	if client == nil {
+		client = defaultWebhookClient()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("webhook返回状态码 %d", resp.StatusCode)
+	}
+	return resp.Status, nil
+}
+
+// Sign 计算webhook签名
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifierFor 按渠道选择投递实现，notifier 配置为 log 时所有渠道只写日志
+func notifierFor(channel string) (Notifier, error) {
+	if config.SystemConfig.Notifier == "log" {
+		return LogNotifier{}, nil
+	}
+	switch channel {
+	case models.ChannelEmail:
+		return SMTPNotifier{}, nil
+	case models.ChannelWebhook:
+		return WebhookNotifier{}, nil
+	}
+	return nil, fmt.Errorf("未知的通知渠道: %s", channel)
+}
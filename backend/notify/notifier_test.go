@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"card-authorization/config"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useWebhookAllowlist 重建webhook客户端，放行 networks 中的地址
+func useWebhookAllowlist(t *testing.T, networks ...string) {
+	t.Helper()
+	old := config.SystemConfig.WebhookAllowedNetworks
+	config.SystemConfig.WebhookAllowedNetworks = networks
+	webhookClientOnce = sync.Once{}
+	t.Cleanup(func() {
+		config.SystemConfig.WebhookAllowedNetworks = old
+		webhookClientOnce = sync.Once{}
+	})
+}
+
+func TestWebhookNotifierSignsPayload(t *testing.T) {
+	useWebhookAllowlist(t, "127.0.0.1")
+
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	payload := `{"event":"card_send","data":{"card_id":1}}`
+	resp, err := WebhookNotifier{}.Notify(context.Background(), &Message{
+		To:     srv.URL,
+		Body:   payload,
+		Event:  "card_send",
+		Secret: "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if resp != "204 No Content" {
+		t.Errorf("resp = %q", resp)
+	}
+	if string(body) != payload {
+		t.Errorf("body = %s, want %s", body, payload)
+	}
+	if got.Method != http.MethodPost || got.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("method = %s, content-type = %s", got.Method, got.Header.Get("Content-Type"))
+	}
+	if e := got.Header.Get("X-Card-Event"); e != "card_send" {
+		t.Errorf("X-Card-Event = %q", e)
+	}
+	timestamp := got.Header.Get("X-Card-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Errorf("X-Card-Timestamp = %q", timestamp)
+	}
+	want := "sha256=" + Sign("s3cret", timestamp, []byte(payload))
+	if sig := got.Header.Get("X-Card-Signature"); sig != want {
+		t.Errorf("X-Card-Signature = %q, want %q", sig, want)
+	}
+	// 密钥不同签名也不同
+	if want == "sha256="+Sign("other", timestamp, []byte(payload)) {
+		t.Error("signature does not depend on secret")
+	}
+}
+
+func TestWebhookNotifierRejectsErrorStatus(t *testing.T) {
+	useWebhookAllowlist(t, "127.0.0.1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if _, err := (WebhookNotifier{}).Notify(context.Background(), &Message{To: srv.URL, Body: "{}"}); err == nil {
+		t.Fatal("expected error for 500 response")
+	}
+}
+
+func TestWebhookNotifierBlocksLocalAddress(t *testing.T) {
+	useWebhookAllowlist(t)
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+
+	_, err := WebhookNotifier{}.Notify(context.Background(), &Message{To: srv.URL, Body: "{}"})
+	if !errors.Is(err, ErrWebhookAddressBlocked) {
+		t.Fatalf("err = %v, want ErrWebhookAddressBlocked", err)
+	}
+	if hit {
+		t.Error("request reached a loopback server")
+	}
+}
+
+func TestWebhookNotifierDoesNotFollowRedirects(t *testing.T) {
+	useWebhookAllowlist(t, "127.0.0.0/8")
+	followed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/internal", http.StatusFound)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	if _, err := (WebhookNotifier{}).Notify(context.Background(), &Message{To: srv.URL + "/hook", Body: "{}"}); err == nil {
+		t.Fatal("expected error for redirect response")
+	}
+	if followed {
+		t.Error("redirect was followed")
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	useWebhookAllowlist(t, "192.168.1.10")
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/hook", true},
+		{"http://93.184.216.34/hook", true},
+		{"ftp://example.com/hook", false},
+		{"http://127.0.0.1:8080/", false},
+		{"http://localhost/hook", false},
+		{"http://10.1.2.3/", false},
+		{"http://172.16.0.1/", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://[::1]/", false},
+		{"http://0.0.0.0/", false},
+		{"http://100.64.0.1/", false},
+		{"http://192.168.1.10/hook", true},
+		{"http://192.168.1.11/hook", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateWebhookURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("ValidateWebhookURL(%q) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Email 邮件渠道的投递内容，按收件人语言渲染模板
+type Email struct {
+	To       string
+	Locale   string         // 收件人语言
+	Template string         // 模板名，见 templates 目录
+	Data     map[string]any // 模板数据
+}
+
+// WebhookPayload 推送给webhook的JSON内容
+type WebhookPayload struct {
+	Event        models.ActivityType  `json:"event"`
+	Notification *models.Notification `json:"notification,omitempty"`
+	SentAt       time.Time            `json:"sent_at"`
+}
+
+// Send 写入站内通知，邮件和webhook放入发件箱由后台投递
+// 按用户的通知偏好决定渠道：关闭站内通知时仍然留档但直接标记为已读；
+// 处于免打扰时段时，邮件和webhook推迟到时段结束再投递；
+// 开启了摘要模式时，摘要涵盖的事件不单独发邮件；邮箱未验证时不发邮件。
+// 只有站内通知写入失败时才返回错误，邮件投递状态记录在 n.EmailStatus 上
+func Send(n *models.Notification, email *Email) error {
+	now := time.Now()
+	pref := PreferenceFor(n.UserID, n.Type)
+	setting := SettingFor(n.UserID)
+	if !pref.InApp {
+		n.ReadAt = &now
+	}
+	switch {
+	case email == nil || email.To == "" || !pref.Email || !EmailVerified(n.UserID):
+		n.EmailStatus = models.EmailStatusNone
+	case CoveredByDigest(&setting, n.Type):
+		n.EmailStatus = models.EmailStatusDigest
+	default:
+		n.EmailStatus = models.EmailStatusPending
+	}
+	if err := database.DB.Create(n).Error; err != nil {
+		log.Error("写入通知失败: %v", err)
+		return err
+	}
+	// 推送给webhook的是替换占位符后的文本
+	n.Render()
+
+	deliverAt := QuietUntil(&setting, now)
+
+	if n.EmailStatus == models.EmailStatusPending {
+		if err := enqueueEmail(n, email, deliverAt); err != nil {
+			log.Error("通知[%d]邮件入队失败: %v", n.ID, err)
+			n.EmailStatus = models.EmailStatusFailed
+			database.DB.Model(n).Update("email_status", n.EmailStatus)
+		}
+	}
+
+	if pref.Webhook {
+		enqueueWebhooks(n, deliverAt)
+	}
+	return nil
+}
+
+// enqueueEmail 渲染邮件模板并放入发件箱
+func enqueueEmail(n *models.Notification, email *Email, deliverAt time.Time) error {
+	unsubscribeURL := UnsubscribeURL(n.UserID, n.Type)
+	data := map[string]any{"UnsubscribeURL": unsubscribeURL}
+	for k, v := range email.Data {
+		data[k] = v
+	}
+	rendered, err := RenderEmail(email.Template, email.Locale, data)
+	if err != nil {
+		return err
+	}
+	return enqueueDelivery(&models.EmailDelivery{
+		UserID:         n.UserID,
+		NotificationID: &n.ID,
+		CardID:         n.CardID,
+		Recipient:      email.To,
+		Template:       email.Template,
+	}, &models.OutboxMessage{
+		NotificationID: &n.ID,
+		Channel:        models.ChannelEmail,
+		To:             email.To,
+		Subject:        rendered.Subject,
+		Body:           rendered.HTML,
+		TextBody:       rendered.Text,
+		UnsubscribeURL: unsubscribeURL,
+		NextAttemptAt:  deliverAt,
+	})
+}
+
+// enqueueWebhooks 投递给用户订阅了该事件的webhook
+func enqueueWebhooks(n *models.Notification, deliverAt time.Time) {
+	var webhooks []models.Webhook
+	if err := database.DB.Where("user_id = ? AND enabled = ?", n.UserID, true).Find(&webhooks).Error; err != nil {
+		log.Error("获取webhook失败: %v", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(WebhookPayload{Event: n.Type, Notification: n, SentAt: time.Now()})
+	if err != nil {
+		log.Error("序列化webhook内容失败: %v", err)
+		return
+	}
+	for i := range webhooks {
+		if !webhooks[i].Subscribes(n.Type) {
+			continue
+		}
+		if err := Enqueue(&models.OutboxMessage{
+			NotificationID: &n.ID,
+			Channel:        models.ChannelWebhook,
+			WebhookID:      &webhooks[i].ID,
+			To:             webhooks[i].URL,
+			Subject:        string(n.Type),
+			Body:           string(body),
+			NextAttemptAt:  deliverAt,
+		}); err != nil {
+			log.Error("通知[%d]webhook入队失败: %v", n.ID, err)
+		}
+	}
+}
+
+// PingWebhook 同步推送一条 ping 事件，用于用户测试webhook配置
+func PingWebhook(ctx context.Context, webhook *models.Webhook) error {
+	notifier, err := notifierFor(models.ChannelWebhook)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(WebhookPayload{Event: "ping", SentAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = notifier.Notify(ctx, &Message{
+		Channel: models.ChannelWebhook,
+		To:      webhook.URL,
+		Body:    string(body),
+		Event:   "ping",
+		Secret:  webhook.Secret,
+	})
+	return err
+}
@@ -0,0 +1,300 @@
+package notify
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/log"
+	"card-authorization/models"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 最长轮询间隔，正常情况下按最早到期的重试时间唤醒
+const outboxPollInterval = 10 * time.Second
+
+var outbox struct {
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func init() {
+	outbox.wake = make(chan struct{}, 1)
+}
+
+// 单次投递超时
+const deliverTimeout = 30 * time.Second
+
+// Enqueue 消息入队，立即返回，由后台协程在 NextAttemptAt 之后投递
+func Enqueue(msg *models.OutboxMessage) error {
+	if msg.Channel == "" {
+		msg.Channel = models.ChannelEmail
+	}
+	msg.Status = models.OutboxStatusPending
+	// 未指定时立即投递，免打扰时段会指定为时段结束时间
+	if msg.NextAttemptAt.IsZero() {
+		msg.NextAttemptAt = time.Now()
+	}
+	if err := database.DB.Create(msg).Error; err != nil {
+		return err
+	}
+	Wake()
+	return nil
+}
+
+// Wake 唤醒投递协程立即检查待发消息
+func Wake() {
+	select {
+	case outbox.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartOutbox 启动发件箱投递协程
+func StartOutbox() {
+	// 上次退出时投递中的消息重新排队
+	database.DB.Model(&models.OutboxMessage{}).
+		Where("status = ?", models.OutboxStatusSending).
+		Update("status", models.OutboxStatusPending)
+
+	outbox.stop = make(chan struct{})
+	jobs := make(chan models.OutboxMessage)
+
+	workers := config.SystemConfig.Outbox.Workers
+	for i := 0; i < workers; i++ {
+		outbox.wg.Add(1)
+		go func() {
+			defer outbox.wg.Done()
+			for msg := range jobs {
+				deliver(&msg)
+			}
+		}()
+	}
+
+	outbox.wg.Add(1)
+	go func() {
+		defer outbox.wg.Done()
+		defer close(jobs)
+		timer := time.NewTimer(outboxPollInterval)
+		defer timer.Stop()
+		for {
+			dispatchDue(jobs)
+			timer.Reset(nextDispatchDelay())
+			select {
+			case <-outbox.stop:
+				return
+			case <-outbox.wake:
+			case <-timer.C:
+			}
+		}
+	}()
+	log.Info("发件箱已启动，投递协程数: %d", workers)
+}
+
+// StopOutbox 停止投递，等待正在投递的消息完成
+func StopOutbox() {
+	if outbox.stop == nil {
+		return
+	}
+	close(outbox.stop)
+	outbox.wg.Wait()
+}
+
+// dispatchDue 认领到期的消息并交给投递协程
+func dispatchDue(jobs chan<- models.OutboxMessage) {
+	var due []models.OutboxMessage
+	if err := database.DB.
+		Where("status = ? AND next_attempt_at <= ?", models.OutboxStatusPending, time.Now()).
+		Order("next_attempt_at").
+		Limit(100).
+		Find(&due).Error; err != nil {
+		log.Error("查询待发消息失败: %v", err)
+		return
+	}
+	for _, msg := range due {
+		// 用状态做乐观锁，防止重复投递
+		result := database.DB.Model(&models.OutboxMessage{}).
+			Where("id = ? AND status = ?", msg.ID, models.OutboxStatusPending).
+			Update("status", models.OutboxStatusSending)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		select {
+		case jobs <- msg:
+		case <-outbox.stop:
+			database.DB.Model(&models.OutboxMessage{}).
+				Where("id = ?", msg.ID).
+				Update("status", models.OutboxStatusPending)
+			return
+		}
+	}
+}
+
+// nextDispatchDelay 距离最早一条待重试消息到期的时间，不超过轮询间隔
+func nextDispatchDelay() time.Duration {
+	var next []models.OutboxMessage
+	if err := database.DB.
+		Where("status = ?", models.OutboxStatusPending).
+		Order("next_attempt_at").
+		Limit(1).
+		Find(&next).Error; err != nil || len(next) == 0 {
+		return outboxPollInterval
+	}
+	return min(max(time.Until(next[0].NextAttemptAt), 100*time.Millisecond), outboxPollInterval)
+}
+
+// permanentError 重试也不会成功的投递错误，例如webhook已删除或停用，消息直接进入死信
+type permanentError struct{ error }
+
+func deliver(msg *models.OutboxMessage) {
+	msg.Attempts++
+	resp, err := send(msg)
+	now := time.Now()
+	// 收件人被永久拒绝时不再重试
+	rejected := err != nil && permanentRejection(err)
+	var permanent permanentError
+
+	updates := map[string]any{"attempts": msg.Attempts}
+	notificationStatus := ""
+	switch {
+	case err == nil:
+		updates["status"] = models.OutboxStatusSent
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+		notificationStatus = models.EmailStatusSent
+	case rejected:
+		log.Error("%s消息[%d]收件人被拒绝，进入死信: %v", msg.Channel, msg.ID, err)
+		updates["status"] = models.OutboxStatusDead
+		updates["last_error"] = err.Error()
+		notificationStatus = models.EmailStatusFailed
+	case errors.As(err, &permanent):
+		log.Warn("%s消息[%d]无法投递，进入死信: %v", msg.Channel, msg.ID, err)
+		updates["status"] = models.OutboxStatusDead
+		updates["last_error"] = err.Error()
+		notificationStatus = models.EmailStatusFailed
+	case msg.Attempts >= config.SystemConfig.Outbox.MaxAttempts:
+		log.Error("%s消息[%d]投递失败%d次，进入死信: %v", msg.Channel, msg.ID, msg.Attempts, err)
+		updates["status"] = models.OutboxStatusDead
+		updates["last_error"] = err.Error()
+		notificationStatus = models.EmailStatusFailed
+	default:
+		delay := retryDelay(msg.Attempts)
+		log.Warn("%s消息[%d]第%d次投递失败，%s后重试: %v", msg.Channel, msg.ID, msg.Attempts, delay, err)
+		updates["status"] = models.OutboxStatusPending
+		updates["last_error"] = err.Error()
+		updates["next_attempt_at"] = now.Add(delay)
+	}
+
+	if err := database.DB.Model(&models.OutboxMessage{}).Where("id = ?", msg.ID).Updates(updates).Error; err != nil {
+		log.Error("更新消息[%d]状态失败: %v", msg.ID, err)
+	}
+	if updates["status"] == models.OutboxStatusPending {
+		// 让投递协程按新的重试时间重新计算等待
+		Wake()
+	}
+	if msg.Channel == models.ChannelEmail {
+		recordDelivery(msg, resp, err, updates["status"] == models.OutboxStatusDead)
+		switch {
+		case err == nil:
+			recordDelivered(msg.To)
+		case rejected:
+			recordBounce(msg.To, err)
+		}
+	}
+	if notificationStatus != "" && msg.NotificationID != nil && msg.Channel == models.ChannelEmail {
+		database.DB.Model(&models.Notification{}).
+			Where("id = ?", *msg.NotificationID).
+			Update("email_status", notificationStatus)
+	}
+}
+
+// send 按渠道投递一条消息，返回对方的响应
+func send(msg *models.OutboxMessage) (string, error) {
+	notifier, err := notifierFor(msg.Channel)
+	if err != nil {
+		return "", err
+	}
+	m := &Message{
+		Channel: msg.Channel,
+		To:      msg.To,
+		Subject: msg.Subject,
+		Body:    msg.Body,
+		Text:    msg.TextBody,
+
+		UnsubscribeURL: msg.UnsubscribeURL,
+	}
+	if msg.Channel == models.ChannelWebhook {
+		var webhook models.Webhook
+		if msg.WebhookID == nil || database.DB.First(&webhook, *msg.WebhookID).Error != nil {
+			return "", permanentError{errors.New("webhook已删除")}
+		}
+		if !webhook.Enabled {
+			return "", permanentError{errors.New("webhook已停用")}
+		}
+		m.Secret = webhook.Secret
+		m.Event = msg.Subject
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deliverTimeout)
+	defer cancel()
+	return notifier.Notify(ctx, m)
+}
+
+// retryDelay 指数退避，加上最多20%的随机抖动
+func retryDelay(attempts int) time.Duration {
+	base := time.Duration(config.SystemConfig.Outbox.BaseDelaySeconds) * time.Second
+	maxDelay := time.Duration(config.SystemConfig.Outbox.MaxDelaySeconds) * time.Second
+	delay := base
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// Retry 把死信或待重试的消息重新排队，立即投递
+func Retry(id uint) (bool, error) {
+	resetDeliveries(database.DB.Model(&models.OutboxMessage{}).
+		Where("id = ? AND status = ?", id, models.OutboxStatusDead))
+	result := database.DB.Model(&models.OutboxMessage{}).
+		Where("id = ? AND status IN ?", id, []string{models.OutboxStatusDead, models.OutboxStatusPending}).
+		Updates(map[string]any{
+			"status":          models.OutboxStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	Wake()
+	return result.RowsAffected > 0, nil
+}
+
+// RetryDead 把所有死信重新排队
+func RetryDead() (int64, error) {
+	resetDeliveries(database.DB.Model(&models.OutboxMessage{}).
+		Where("status = ?", models.OutboxStatusDead))
+	result := database.DB.Model(&models.OutboxMessage{}).
+		Where("status = ?", models.OutboxStatusDead).
+		Updates(map[string]any{
+			"status":          models.OutboxStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	Wake()
+	return result.RowsAffected, nil
+}
+
+// resetDeliveries 死信重新排队时，对应的投递记录也回到等待状态
+func resetDeliveries(dead *gorm.DB) {
+	database.DB.Model(&models.EmailDelivery{}).
+		Where("id IN (?)", dead.Select("delivery_id")).
+		Update("status", models.DeliveryStatusPending)
+}
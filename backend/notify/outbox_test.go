@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/models"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeliverDeadLettersRemovedWebhook(t *testing.T) {
+	if err := database.Open(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	config.SystemConfig.Outbox.MaxAttempts = 5
+
+	disabled := models.Webhook{UserID: 1, URL: "https://hooks.example.com/a", Secret: "s"}
+	database.DB.Create(&disabled)
+	database.DB.Model(&disabled).Update("enabled", false)
+	missing := uint(disabled.ID + 1)
+
+	for name, webhookID := range map[string]*uint{"disabled": &disabled.ID, "deleted": &missing} {
+		msg := models.OutboxMessage{
+			Channel:       models.ChannelWebhook,
+			WebhookID:     webhookID,
+			To:            "https://hooks.example.com/a",
+			Status:        models.OutboxStatusSending,
+			NextAttemptAt: time.Now(),
+		}
+		if err := database.DB.Create(&msg).Error; err != nil {
+			t.Fatal(err)
+		}
+		deliver(&msg)
+		database.DB.First(&msg, msg.ID)
+		if msg.Status != models.OutboxStatusDead || msg.Attempts != 1 {
+			t.Errorf("%s webhook: status = %s after %d attempts, want dead after 1", name, msg.Status, msg.Attempts)
+		}
+	}
+}
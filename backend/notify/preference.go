@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"card-authorization/config"
+	"card-authorization/database"
+	"card-authorization/models"
+	"card-authorization/utils"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // 服务器可能没有安装时区数据
+
+	"gorm.io/gorm/clause"
+)
+
+// 默认时区
+const DefaultTimezone = "Asia/Shanghai"
+
+// 退订链接有效期
+const unsubscribeTTL = 90 * 24 * time.Hour
+
+// PreferenceEvent 可以单独设置通知渠道的事件
+type PreferenceEvent struct {
+	Event models.ActivityType `json:"event"`
+	Label string              `json:"label"`
+}
+
+var PreferenceEvents = []PreferenceEvent{
+	{models.ActivityCardSend, "收到卡片"},
+	{models.ActivityCardUse, "卡片被使用"},
+	{models.ActivityFriendInvite, "收到道友邀请"},
+	{models.ActivityFriendAccept, "道友邀请被接受"},
+	{models.NotificationCardExpiring, "卡片即将过期"},
+}
+
+// PreferenceFor 用户对某类事件的通知渠道，没有设置时全部启用
+func PreferenceFor(userID uint, event models.ActivityType) models.NotificationPreference {
+	pref := models.NotificationPreference{UserID: userID, Event: event, Email: true, InApp: true, Webhook: true}
+	database.DB.Where("user_id = ? AND event = ?", userID, event).Limit(1).Find(&pref)
+	return pref
+}
+
+// SettingFor 用户的通知全局设置，没有设置时使用默认时区且不启用免打扰
+func SettingFor(userID uint) models.NotificationSetting {
+	setting := models.NotificationSetting{UserID: userID, Timezone: DefaultTimezone, DigestMode: models.DigestModeOff}
+	database.DB.Where("user_id = ?", userID).Limit(1).Find(&setting)
+	return setting
+}
+
+// SavePreference 新增或更新某类事件的通知渠道
+func SavePreference(pref *models.NotificationPreference) error {
+	return database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "event"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "in_app", "webhook"}),
+	}).Create(pref).Error
+}
+
+// QuietUntil 当前处于免打扰时段时返回时段结束的时间，否则返回零值
+// 返回值转换为服务器本地时区，sqlite 按字符串比较时间，时区必须和其他记录一致
+func QuietUntil(setting *models.NotificationSetting, now time.Time) time.Time {
+	until := quietUntil(setting, now)
+	if until.IsZero() {
+		return until
+	}
+	return until.Local()
+}
+
+func quietUntil(setting *models.NotificationSetting, now time.Time) time.Time {
+	start, okStart := parseClock(setting.QuietStart)
+	end, okEnd := parseClock(setting.QuietEnd)
+	if !okStart || !okEnd || start == end {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(setting.Timezone)
+	if err != nil {
+		loc, _ = time.LoadLocation(DefaultTimezone)
+	}
+	local := now.In(loc)
+	minutes := local.Hour()*60 + local.Minute()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	if start < end {
+		// 同一天内，如 13:00-14:00
+		if minutes >= start && minutes < end {
+			return midnight.Add(time.Duration(end) * time.Minute)
+		}
+		return time.Time{}
+	}
+	// 跨天，如 22:00-08:00
+	if minutes >= start {
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(end) * time.Minute)
+	}
+	if minutes < end {
+		return midnight.Add(time.Duration(end) * time.Minute)
+	}
+	return time.Time{}
+}
+
+// ValidClock 校验 HH:MM 格式
+func ValidClock(s string) bool {
+	_, ok := parseClock(s)
+	return ok
+}
+
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// UnsubscribeURL 生成一键退订某类事件邮件的链接
+func UnsubscribeURL(userID uint, event models.ActivityType) string {
+	token := utils.SignToken([]byte(config.SystemConfig.SecretKey), "unsubscribe",
+		fmt.Sprintf("%d:%s", userID, event), unsubscribeTTL)
+	return config.SystemConfig.PublicURL + "/api/notifications/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// parseUnsubscribeToken 校验退订链接，返回用户和事件
+func parseUnsubscribeToken(token string) (uint, models.ActivityType, error) {
+	payload, err := utils.VerifyToken([]byte(config.SystemConfig.SecretKey), "unsubscribe", token)
+	if err != nil {
+		return 0, "", err
+	}
+	id, event, ok := strings.Cut(payload, ":")
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if !ok || err != nil {
+		return 0, "", utils.ErrTokenInvalid
+	}
+	return uint(userID), models.ActivityType(event), nil
+}
+
+// UnsubscribeLabel 校验退订链接，返回要退订的邮件类别，用于确认页面，不修改设置
+func UnsubscribeLabel(token string) (string, error) {
+	_, event, err := parseUnsubscribeToken(token)
+	if err != nil {
+		return "", err
+	}
+	if event == digestEvent {
+		return "通知摘要", nil
+	}
+	for _, e := range PreferenceEvents {
+		if e.Event == event {
+			return e.Label, nil
+		}
+	}
+	return string(event), nil
+}
+
+// Unsubscribe 校验退订链接并关闭对应事件的邮件通知，摘要邮件的退订会关闭摘要涵盖的所有事件
+func Unsubscribe(token string) (models.ActivityType, error) {
+	userID, event, err := parseUnsubscribeToken(token)
+	if err != nil {
+		return "", err
+	}
+	if event == digestEvent {
+		for _, e := range DigestEvents {
+			pref := PreferenceFor(userID, e)
+			pref.Email = false
+			if err := SavePreference(&pref); err != nil {
+				return "", err
+			}
+		}
+		return digestEvent, nil
+	}
+	pref := PreferenceFor(userID, event)
+	pref.Email = false
+	return pref.Event, SavePreference(&pref)
+}
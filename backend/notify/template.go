@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"bytes"
+	"card-authorization/config"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// 内置的邮件模板，配置了 email.template_dir 时同名文件优先使用目录中的
+//
+// 目录结构：
+//
+//	layout.html / layout.txt          公共布局
+//	<locale>/common.tmpl              公共文案（页脚、链接文字等）
+//	<locale>/<name>.html              HTML正文，定义 heading 和 content
+//	<locale>/<name>.txt               纯文本正文，定义 subject 和 content
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+const (
+	LocaleZhCN    = "zh-CN"
+	LocaleEn      = "en"
+	DefaultLocale = LocaleZhCN
+)
+
+// RenderedEmail 渲染后的邮件
+type RenderedEmail struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+type emailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+var templateCache sync.Map // locale/name -> *emailTemplate
+
+// NormalizeLocale 把 en-US、zh 等归一为支持的语言，未知的使用默认语言
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if strings.HasPrefix(locale, "en") {
+		return LocaleEn
+	}
+	return DefaultLocale
+}
+
+// LocaleFromAcceptLanguage 根据 Accept-Language 头选择语言
+func LocaleFromAcceptLanguage(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	first, _, _ = strings.Cut(first, ";")
+	return NormalizeLocale(first)
+}
+
+// RenderEmail 按收件人语言渲染邮件模板
+// data 中可以使用 ActionURL 覆盖按钮链接，默认为应用首页；UnsubscribeURL 为空时不显示退订链接
+func RenderEmail(name, locale string, data map[string]any) (*RenderedEmail, error) {
+	locale = NormalizeLocale(locale)
+	tpl, err := loadTemplate(name, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]any{
+		"Locale":    locale,
+		"PublicURL": config.SystemConfig.PublicURL,
+		"ActionURL": config.SystemConfig.PublicURL + "/",
+	}
+	for k, v := range data {
+		values[k] = v
+	}
+	if _, ok := values["UnsubscribeURL"]; !ok {
+		values["UnsubscribeURL"] = ""
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tpl.text.ExecuteTemplate(&subject, "subject", values); err != nil {
+		return nil, err
+	}
+	if err := tpl.text.ExecuteTemplate(&text, "layout", values); err != nil {
+		return nil, err
+	}
+	if err := tpl.html.ExecuteTemplate(&html, "layout", values); err != nil {
+		return nil, err
+	}
+	return &RenderedEmail{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+func loadTemplate(name, locale string) (*emailTemplate, error) {
+	key := locale + "/" + name
+	if tpl, ok := templateCache.Load(key); ok {
+		return tpl.(*emailTemplate), nil
+	}
+
+	fsys := templateFS()
+	html, err := htmltemplate.ParseFS(fsys, "layout.html", locale+"/common.tmpl", locale+"/"+name+".html")
+	if err != nil {
+		return nil, err
+	}
+	text, err := texttemplate.ParseFS(fsys, "layout.txt", locale+"/common.tmpl", locale+"/"+name+".txt")
+	if err != nil {
+		return nil, err
+	}
+	tpl := &emailTemplate{html: html, text: text}
+	templateCache.Store(key, tpl)
+	return tpl, nil
+}
+
+// templateFS 配置目录优先，找不到的文件回退到内置模板
+func templateFS() fs.FS {
+	embedded, _ := fs.Sub(embeddedTemplates, "templates")
+	if dir := config.SystemConfig.EmailConfig.TemplateDir; dir != "" {
+		return overlayFS{primary: os.DirFS(dir), fallback: embedded}
+	}
+	return embedded
+}
+
+type overlayFS struct {
+	primary  fs.FS
+	fallback fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.primary.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.fallback.Open(name)
+	}
+	return f, err
+}
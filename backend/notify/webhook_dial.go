@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"card-authorization/config"
+	"card-authorization/log"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrWebhookAddressBlocked webhook指向回环、内网、链路本地等地址
+var ErrWebhookAddressBlocked = errors.New("webhook地址指向内网或本机")
+
+// 运营商级NAT地址段，net.IP 没有对应的判断方法
+var sharedAddressSpace = mustParseCIDR("100.64.0.0/10")
+
+var (
+	webhookClientOnce sync.Once
+	webhookClient     *http.Client
+	webhookAllowed    []*net.IPNet
+)
+
+// defaultWebhookClient 推送webhook使用的客户端
+// 在建立连接时检查对方IP，域名解析到内网（包括DNS重绑定）也会被拦下；不跟随重定向，不使用环境变量中的代理
+func defaultWebhookClient() *http.Client {
+	webhookClientOnce.Do(func() {
+		webhookAllowed = parseNetworks(config.SystemConfig.WebhookAllowedNetworks)
+		dialer := &net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				return checkWebhookIP(net.ParseIP(host))
+			},
+		}
+		webhookClient = &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 5 * time.Second,
+				MaxIdleConns:        20,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	})
+	return webhookClient
+}
+
+// checkWebhookIP 拒绝不应该从服务端访问的地址，webhook_allowed_networks 中的地址段除外
+func checkWebhookIP(ip net.IP) error {
+	if ip == nil {
+		return ErrWebhookAddressBlocked
+	}
+	for _, n := range webhookAllowed {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w: %s", ErrWebhookAddressBlocked, ip)
+	}
+	return nil
+}
+
+// ValidateWebhookURL 注册时的检查：只允许http和https，地址是IP时不能指向内网
+// 域名在推送建立连接时才检查解析结果
+func ValidateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("webhook地址只支持http或https")
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		host = "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		defaultWebhookClient()
+		return checkWebhookIP(ip)
+	}
+	return nil
+}
+
+// parseNetworks 解析地址段，单个IP按 /32 或 /128 处理
+func parseNetworks(values []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			log.Warn("webhook_allowed_networks 中的 %s 无效，已忽略", v)
+			continue
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -1,92 +1,98 @@
 package utils
 
 import (
+	"bytes"
 	"card-authorization/config"
 	"card-authorization/log"
-	"crypto/tls"
-	"net/smtp"
-	"strconv"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"mime"
 	"strings"
+	"time"
 )
 
-// SendEmail 发送邮件
+// SendEmail 发送HTML邮件
 func SendEmail(to, subject, body string) error {
-	// 发件人信息
-	from := config.SystemConfig.EmailConfig.AuthEmail
-	password := config.SystemConfig.EmailConfig.AuthPassword // 注意：这里必须使用QQ邮箱的SMTP授权码，而非登录密码
-	smtpHost := config.SystemConfig.EmailConfig.SMTPHost
-	smtpPort := strconv.Itoa(config.SystemConfig.EmailConfig.SMTPPort) // 465端口需要SSL加密
-
-	// 构建邮件内容（必须包含标准头部）
-	// 头部与正文之间需用空行(\r\n\r\n)分隔
-	msg := []byte("To: " + to + "\r\n" +
-		"From: " + from + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"MIME-Version: 1.0\r\n" +
-		"Content-Type: text/html; charset=utf-8\r\n" + // 核心修复：指定为HTML格式
-		"\r\n" + // 头部结束标记（必须有）
-		body + "\r\n")
-
-	// 构建认证信息（服务器地址不含端口）
-	auth := smtp.PlainAuth("", from, password, smtpHost)
+	_, err := SendMultipartEmail(context.Background(), to, subject, body, "", "")
+	return err
+}
 
-	// 465端口需要手动建立TLS连接
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: false,
-		ServerName:         smtpHost,
-	}
+// SendMultipartEmail 发送HTML邮件，textBody 不为空时附带纯文本版本（multipart/alternative）
+// 投递方式由 email.transport 决定，见 MailTransport.go；返回服务器的响应
+// ctx 取消或到期时中断与服务器的交互；unsubscribeURL 见 BuildMessage
+func SendMultipartEmail(ctx context.Context, to, subject, htmlBody, textBody, unsubscribeURL string) (string, error) {
+	from := config.SystemConfig.EmailConfig.AuthEmail
+	msg := BuildMessage(from, to, subject, htmlBody, textBody, unsubscribeURL)
 
-	// 连接SMTP服务器（带SSL加密）
-	conn, err := tls.Dial("tcp", smtpHost+":"+smtpPort, tlsConfig)
-	if err != nil {
-		log.Error("连接服务器失败：%v", err)
-		return err
+	// 设置收件人（支持多个收件人）
+	var recipients []string
+	for _, addr := range strings.Split(to, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			recipients = append(recipients, addr)
+		}
 	}
-	defer conn.Close()
 
-	// 创建SMTP客户端
-	client, err := smtp.NewClient(conn, smtpHost)
+	resp, err := mailTransport().Send(ctx, from, recipients, msg)
 	if err != nil {
-		log.Error("创建客户端失败：%v", err)
-		return err
+		log.Error("邮件发送失败：%v", err)
+		return "", err
 	}
-	defer client.Quit()
+	log.Info("邮件发送成功：%s", resp)
+	return resp, nil
+}
 
-	// 进行认证
-	if err := client.Auth(auth); err != nil {
-		log.Error("认证失败：%v", err)
-		return err
+// BuildMessage 构建邮件原文，主题按RFC 2047编码，正文使用base64避免长行和非ASCII字符问题
+// unsubscribeURL 不为空时附带 List-Unsubscribe 头，邮件客户端可以按RFC 8058向该地址POST一键退订
+func BuildMessage(from, to, subject, htmlBody, textBody, unsubscribeURL string) []byte {
+	var buf bytes.Buffer
+	// 头部与正文之间需用空行(\r\n\r\n)分隔
+	buf.WriteString("To: " + to + "\r\n")
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	if unsubscribeURL != "" {
+		buf.WriteString("List-Unsubscribe: <" + unsubscribeURL + ">\r\n")
+		buf.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
 	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
 
-	// 设置发件人
-	if err := client.Mail(from); err != nil {
-		log.Error("设置发件人失败：%v", err)
-		return err
+	if textBody == "" {
+		buf.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64(&buf, htmlBody)
+		return buf.Bytes()
 	}
 
-	// 设置收件人（支持多个收件人）
-	for _, addr := range strings.Split(to, ",") {
-		if err := client.Rcpt(addr); err != nil {
-			log.Error("设置收件人失败：%v", err)
-			return err
-		}
+	boundary := randomBoundary()
+	buf.WriteString("Content-Type: multipart/alternative; boundary=\"" + boundary + "\"\r\n\r\n")
+	// 纯文本在前，客户端会优先显示最后一个能识别的版本
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", textBody},
+		{"text/html", htmlBody},
+	} {
+		buf.WriteString("--" + boundary + "\r\n")
+		buf.WriteString("Content-Type: " + part.contentType + "; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64(&buf, part.body)
 	}
+	buf.WriteString("--" + boundary + "--\r\n")
+	return buf.Bytes()
+}
 
-	// 发送邮件内容
-	data, err := client.Data()
-	if err != nil {
-		log.Error("准备发送内容失败：%v", err)
-		return err
-	}
-	defer data.Close()
-
-	// 写入邮件内容
-	_, err = data.Write(msg)
-	if err != nil {
-		log.Error("发送内容失败：%v", err)
-		return err
+// writeBase64 按每行76个字符写入base64正文
+func writeBase64(buf *bytes.Buffer, body string) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
 	}
+	buf.WriteString(encoded + "\r\n")
+}
 
-	log.Info("邮件发送成功")
-	return nil
+func randomBoundary() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("card-%x", b)
 }
@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"card-authorization/config"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 空闲连接超过该时间不再复用，大多数服务器会主动断开长时间空闲的连接
+const smtpIdleTimeout = 30 * time.Second
+
+// MailTransport 邮件投递方式，Send 返回服务器的响应，ctx 取消时应尽快返回
+type MailTransport interface {
+	Send(ctx context.Context, from string, to []string, msg []byte) (string, error)
+	Close()
+}
+
+// RecipientError 服务器拒绝了收件人
+type RecipientError struct {
+	Addr string
+	Err  error
+}
+
+func (e *RecipientError) Error() string {
+	return fmt.Sprintf("收件人 %s 被拒绝：%v", e.Addr, e.Err)
+}
+
+func (e *RecipientError) Unwrap() error { return e.Err }
+
+// Permanent 5xx 表示地址不存在等永久性错误，重试也不会成功
+func (e *RecipientError) Permanent() bool {
+	var tpErr *textproto.Error
+	return errors.As(e.Err, &tpErr) && tpErr.Code >= 500
+}
+
+var (
+	transportOnce sync.Once
+	transport     MailTransport
+)
+
+// mailTransport 按配置创建投递方式，首次发送时初始化
+func mailTransport() MailTransport {
+	transportOnce.Do(func() {
+		cfg := config.SystemConfig.EmailConfig
+		if cfg.Transport == "file" {
+			transport = &FileTransport{Dir: cfg.SinkDir}
+			return
+		}
+		transport = &SMTPPool{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.AuthEmail,
+			Password: cfg.AuthPassword,
+			Mode:     cfg.Transport,
+			Timeout:  time.Duration(cfg.TimeoutSeconds) * time.Second,
+			Size:     cfg.PoolSize,
+		}
+	})
+	return transport
+}
+
+// CloseMailTransport 关闭空闲的SMTP连接，服务退出时调用
+func CloseMailTransport() {
+	if transport != nil {
+		transport.Close()
+	}
+}
+
+// SMTPPool 复用SMTP连接的投递方式
+type SMTPPool struct {
+	Host     string
+	Port     int
+	Username string
+	Password string // QQ邮箱等需要使用SMTP授权码，而非登录密码
+	Mode     string // tls、starttls 或 plain
+	Timeout  time.Duration
+	Size     int // 最多保持的空闲连接数
+
+	mu   sync.Mutex
+	idle []*smtpConn
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func (p *SMTPPool) Send(ctx context.Context, from string, to []string, msg []byte) (string, error) {
+	c, err := p.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	// ctx 取消时把截止时间设为现在，正在阻塞的读写立即返回
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Now()) })
+	resp, err := p.send(ctx, c, from, to, msg)
+	if !stop() || err != nil {
+		// 出错或被取消的连接状态未知，不再复用
+		c.client.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", fmt.Errorf("发送中断：%w", ctxErr)
+		}
+		return "", err
+	}
+	p.put(c)
+	return resp, nil
+}
+
+// deadline 单次交互的截止时间，ctx 的截止时间更早时以 ctx 为准
+func (p *SMTPPool) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(p.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return deadline
+}
+
+func (p *SMTPPool) send(ctx context.Context, c *smtpConn, from string, to []string, msg []byte) (string, error) {
+	c.conn.SetDeadline(p.deadline(ctx))
+	if err := c.client.Mail(from); err != nil {
+		return "", fmt.Errorf("设置发件人失败：%w", err)
+	}
+	for _, addr := range to {
+		if err := c.client.Rcpt(addr); err != nil {
+			return "", &RecipientError{Addr: addr, Err: err}
+		}
+	}
+	// smtp.Client.Data 不返回结束DATA时的响应，这里直接走协议以便记录服务器的回复
+	text := c.client.Text
+	id, err := text.Cmd("DATA")
+	if err != nil {
+		return "", fmt.Errorf("准备发送内容失败：%w", err)
+	}
+	text.StartResponse(id)
+	_, _, err = text.ReadResponse(354)
+	text.EndResponse(id)
+	if err != nil {
+		return "", fmt.Errorf("准备发送内容失败：%w", err)
+	}
+	w := text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return "", fmt.Errorf("发送内容失败：%w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("发送内容失败：%w", err)
+	}
+	// 服务器在结束DATA时才返回是否接受这封邮件
+	code, resp, err := text.ReadResponse(250)
+	if err != nil {
+		return "", fmt.Errorf("服务器拒收：%w", err)
+	}
+	return fmt.Sprintf("%d %s", code, resp), nil
+}
+
+// get 取一个可用的空闲连接，没有时新建
+func (p *SMTPPool) get(ctx context.Context) (*smtpConn, error) {
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if time.Since(c.lastUsed) > smtpIdleTimeout {
+			c.client.Close()
+			continue
+		}
+		p.mu.Unlock()
+		c.conn.SetDeadline(p.deadline(ctx))
+		if err := c.client.Noop(); err == nil {
+			return c, nil
+		}
+		c.client.Close()
+		p.mu.Lock()
+	}
+	p.mu.Unlock()
+	return p.dial(ctx)
+}
+
+// put 归还连接，空闲连接已满时断开
+func (p *SMTPPool) put(c *smtpConn) {
+	if err := c.client.Reset(); err != nil {
+		c.client.Close()
+		return
+	}
+	c.lastUsed = time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) >= p.Size {
+		c.client.Quit()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (p *SMTPPool) dial(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+	tlsConfig := &tls.Config{ServerName: p.Host}
+
+	dialer := net.Dialer{Deadline: p.deadline(ctx)}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接服务器失败：%w", err)
+	}
+	// 握手和认证期间 ctx 被取消时同样中断
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	conn.SetDeadline(p.deadline(ctx))
+	if p.Mode == "tls" {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS握手失败：%w", err)
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, p.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("创建客户端失败：%w", err)
+	}
+	if p.Mode == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("服务器不支持STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("STARTTLS失败：%w", err)
+		}
+	}
+	// 本地中继通常不需要认证
+	if p.Password != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", p.Username, p.Password, p.Host)); err != nil {
+				client.Close()
+				return nil, fmt.Errorf("认证失败：%w", err)
+			}
+		}
+	}
+	return &smtpConn{conn: conn, client: client, lastUsed: time.Now()}, nil
+}
+
+func (p *SMTPPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.idle {
+		c.client.Quit()
+	}
+	p.idle = nil
+}
+
+// FileTransport 不连接服务器，按maildir格式把邮件写成 .eml 文件
+// 先写入 tmp/ 再移动到 new/，读取方不会看到写了一半的文件
+type FileTransport struct {
+	Dir string
+}
+
+func (f *FileTransport) Send(ctx context.Context, from string, to []string, msg []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(f.Dir, sub), 0o755); err != nil {
+			return "", err
+		}
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	name := fmt.Sprintf("%d.%x.eml", time.Now().UnixNano(), b)
+	tmp := filepath.Join(f.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, msg, 0o644); err != nil {
+		return "", err
+	}
+	path := filepath.Join(f.Dir, "new", name)
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	return "saved " + path, nil
+}
+
+func (f *FileTransport) Close() {}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"card-authorization/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcCacheTTL 发现文档和公钥的缓存时间
+const oidcCacheTTL = time.Hour
+
+// oidcRefreshInterval 遇到未知kid时强制重新获取公钥的最短间隔，
+// 伪造kid的请求不能让每次回调都去请求身份提供方并占住锁
+const oidcRefreshInterval = time.Minute
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// OIDCClaims ID token 中用到的声明
+type OIDCClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// OIDCClient 一个身份提供方的客户端，缓存发现文档和签名公钥
+type OIDCClient struct {
+	Config      config.OIDCProvider
+	RedirectURL string
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]any
+	fetchedAt time.Time
+	refreshAt time.Time // 最近一次强制刷新的时间，不论成功与否
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	oidcClients     map[string]*OIDCClient
+	oidcClientsOnce sync.Once
+)
+
+// OIDCClientFor 按名称获取配置中的身份提供方，没有配置时返回 nil
+func OIDCClientFor(name string) *OIDCClient {
+	oidcClientsOnce.Do(func() {
+		oidcClients = map[string]*OIDCClient{}
+		for _, provider := range config.SystemConfig.OIDC {
+			oidcClients[provider.Name] = &OIDCClient{
+				Config:      provider,
+				RedirectURL: strings.TrimRight(config.SystemConfig.PublicURL, "/") + "/api/oidc/" + url.PathEscape(provider.Name) + "/callback",
+			}
+		}
+	})
+	return oidcClients[name]
+}
+
+// PKCEChallenge 按 S256 方法计算 code_challenge
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL 授权地址，使用 PKCE
+func (o *OIDCClient) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	d, err := o.load(false)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", o.Config.ClientID)
+	query.Set("redirect_uri", o.RedirectURL)
+	query.Set("scope", strings.Join(o.Config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", PKCEChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange 用授权码换取 ID token 并校验签名、签发方、受众、有效期和 nonce
+func (o *OIDCClient) Exchange(code, verifier, nonce string) (*OIDCClaims, error) {
+	d, err := o.load(false)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.RedirectURL)
+	form.Set("client_id", o.Config.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.Config.ClientID), url.QueryEscape(o.Config.ClientSecret))
+	}
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token接口返回 %s: %s", resp.Status, body)
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return nil, errors.New("token接口没有返回 id_token")
+	}
+
+	claims := &OIDCClaims{}
+	if _, err := jwt.ParseWithClaims(token.IDToken, claims, o.keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(o.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	); err != nil {
+		return nil, fmt.Errorf("id_token校验失败: %w", err)
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id_token nonce 不匹配")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id_token 缺少 sub")
+	}
+	return claims, nil
+}
+
+// keyFunc 按 kid 查找公钥，找不到时重新获取一次，以支持身份提供方轮换密钥
+// 强制刷新每 oidcRefreshInterval 最多一次，期间未知的kid直接返回错误
+func (o *OIDCClient) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, refresh := range []bool{false, true} {
+		if _, err := o.load(refresh); err != nil {
+			return nil, err
+		}
+		o.mu.Lock()
+		key, ok := o.keys[kid]
+		if !ok && kid == "" && len(o.keys) == 1 {
+			for _, k := range o.keys {
+				key, ok = k, true
+			}
+		}
+		o.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("未知的kid: %s", kid)
+}
+
+// load 获取发现文档和公钥，缓存 oidcCacheTTL；refresh 为 true 时忽略缓存，但距上次强制刷新不足 oidcRefreshInterval 时仍使用缓存
+func (o *OIDCClient) load(refresh bool) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		if !refresh && time.Since(o.fetchedAt) < oidcCacheTTL {
+			return o.discovery, nil
+		}
+		if refresh && time.Since(o.refreshAt) < oidcRefreshInterval {
+			return o.discovery, nil
+		}
+	}
+	if refresh {
+		o.refreshAt = time.Now()
+	}
+
+	var d oidcDiscovery
+	if err := getJSON(strings.TrimRight(o.Config.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("获取OIDC发现文档失败: %w", err)
+	}
+	if d.Issuer != o.Config.Issuer && d.Issuer != strings.TrimRight(o.Config.Issuer, "/") {
+		return nil, fmt.Errorf("发现文档的issuer %s 与配置不一致", d.Issuer)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("获取OIDC公钥失败: %w", err)
+	}
+
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	o.discovery = &d
+	o.keys = keys
+	o.fetchedAt = time.Now()
+	return o.discovery, nil
+}
+
+func getJSON(u string, out any) error {
+	resp, err := oidcHTTPClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回 %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
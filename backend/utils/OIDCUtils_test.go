@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"card-authorization/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOIDCKeyFuncLimitsForcedRefresh(t *testing.T) {
+	var jwksFetches atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": srv.URL, "jwks_uri": srv.URL + "/jwks"})
+		case "/jwks":
+			jwksFetches.Add(1)
+			json.NewEncoder(w).Encode(map[string]any{"keys": []any{}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	client := &OIDCClient{Config: config.OIDCProvider{Issuer: srv.URL}}
+	token := &jwt.Token{Header: map[string]any{"kid": "forged"}}
+
+	// 第一次遇到未知kid：正常加载一次，再强制刷新一次
+	if _, err := client.keyFunc(token); err == nil {
+		t.Fatal("unknown kid was accepted")
+	}
+	if got := jwksFetches.Load(); got != 2 {
+		t.Fatalf("jwks fetched %d times, want 2", got)
+	}
+	// 间隔内的未知kid直接失败，不再请求身份提供方
+	for i := 0; i < 5; i++ {
+		if _, err := client.keyFunc(token); err == nil {
+			t.Fatal("unknown kid was accepted")
+		}
+	}
+	if got := jwksFetches.Load(); got != 2 {
+		t.Errorf("jwks fetched %d times within the refresh interval, want 2", got)
+	}
+
+	// 间隔过后允许再刷新一次
+	client.mu.Lock()
+	client.refreshAt = time.Now().Add(-oidcRefreshInterval)
+	client.mu.Unlock()
+	client.keyFunc(token)
+	if got := jwksFetches.Load(); got != 3 {
+		t.Errorf("jwks fetched %d times after the refresh interval, want 3", got)
+	}
+}
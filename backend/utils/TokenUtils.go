@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenInvalid = errors.New("token无效")
+	ErrTokenExpired = errors.New("token已过期")
+)
+
+// SignToken 生成带过期时间的签名token，格式为 base64(purpose|payload|过期时间).base64(签名)
+// purpose 区分用途，防止一种链接被拿去当另一种用
+func SignToken(secret []byte, purpose, payload string, ttl time.Duration) string {
+	data := purpose + "|" + payload + "|" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(data))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(secret, encoded))
+}
+
+// VerifyToken 校验签名token，返回签名时的 payload
+func VerifyToken(secret []byte, purpose, token string) (string, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrTokenInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, tokenMAC(secret, encoded)) {
+		return "", ErrTokenInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || parts[0] != purpose {
+		return "", ErrTokenInvalid
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", ErrTokenExpired
+	}
+	return parts[1], nil
+}
+
+func tokenMAC(secret []byte, data string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// RandomToken 生成随机token，数据库中只保存 HashToken 的结果
+func RandomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// HashToken 计算token的SHA-256，随机token熵足够高，不需要加盐
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -52,10 +52,18 @@ document.getElementById('loginForm')?.addEventListener('submit', async (e) => {
             body: JSON.stringify({ username, password })
         });
         
-        const data = await response.json();
+        let data = await response.json();
+
+        // 开启了两步验证，继续输入验证码
+        if (response.ok && data.mfa_required) {
+            data = await submitMFACode(data.mfa_token);
+            if (!data) {
+                return;
+            }
+        }
         
         if (response.ok) {
-            localStorage.setItem('token', data.token);
+            saveTokens(data);
             localStorage.setItem('user', JSON.stringify(data.user));
             if (rememberMe) {
                 // 勾选了记住密码，存储30天
@@ -68,6 +76,8 @@ document.getElementById('loginForm')?.addEventListener('submit', async (e) => {
                 removeCookie('userPwd');
             }
             window.location.href = '/dashboard';
+        } else if (response.status === 429) {
+            alert(`${data.error}（${response.headers.get('Retry-After')}秒后可以重试）`);
         } else {
             alert(data.error || '登录失败');
         }
@@ -76,6 +86,29 @@ document.getElementById('loginForm')?.addEventListener('submit', async (e) => {
     }
 });
 
+// 输入两步验证码完成登录，验证失败可以重新输入，取消时返回 null
+async function submitMFACode(mfaToken) {
+    for (;;) {
+        const code = prompt('请输入验证器中的6位验证码，或者一个恢复码：');
+        if (!code) {
+            return null;
+        }
+        const response = await fetch('/api/login/mfa', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ mfa_token: mfaToken, code: code.trim() })
+        });
+        const data = await response.json();
+        if (response.ok) {
+            return data;
+        }
+        alert(data.error || '验证失败');
+        if (response.status !== 401 || data.error !== '验证码错误') {
+            return null;
+        }
+    }
+}
+
 // 注册
 document.getElementById('registerForm')?.addEventListener('submit', async (e) => {
     e.preventDefault();
@@ -96,7 +129,7 @@ document.getElementById('registerForm')?.addEventListener('submit', async (e) =>
         const data = await response.json();
         
         if (response.ok) {
-            localStorage.setItem('token', data.token);
+            saveTokens(data);
             localStorage.setItem('user', JSON.stringify(data.user));
             window.location.href = '/dashboard';
         } else {
@@ -107,9 +140,193 @@ document.getElementById('registerForm')?.addEventListener('submit', async (e) =>
     }
 });
 
-// 退出登录
-function logout() {
+// 忘记密码
+document.getElementById('forgotPasswordForm')?.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const email = document.getElementById('email').value;
+
+    try {
+        const response = await fetch('/api/password/forgot', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ email })
+        });
+
+        const data = await response.json();
+
+        if (response.ok) {
+            alert(data.message);
+            window.location.href = '/login';
+        } else {
+            alert(data.error || '发送失败');
+        }
+    } catch (error) {
+        alert('网络错误，请重试');
+    }
+});
+
+// 重置密码
+document.getElementById('resetPasswordForm')?.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const password = document.getElementById('password').value;
+    const confirmPassword = document.getElementById('confirmPassword').value;
+    const token = new URLSearchParams(window.location.search).get('token');
+
+    if (password !== confirmPassword) {
+        alert('两次输入的密码不一致');
+        return;
+    }
+    try {
+        const response = await fetch('/api/password/reset', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ token, password })
+        });
+
+        const data = await response.json();
+
+        if (response.ok) {
+            // 其他设备上的登录已失效，这里也一并清除
+            clearTokens();
+            localStorage.removeItem('user');
+            removeCookie('userPwd');
+            alert(data.message);
+            window.location.href = '/login';
+        } else {
+            alert(data.error || '重置失败');
+        }
+    } catch (error) {
+        alert('网络错误，请重试');
+    }
+});
+
+// 申请邮件登录链接
+document.getElementById('magicLinkForm')?.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const email = document.getElementById('magicLinkEmail').value;
+
+    try {
+        const response = await fetch('/api/login/magic', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ email })
+        });
+
+        const data = await response.json();
+
+        if (response.ok) {
+            alert(data.message);
+        } else if (response.status === 429) {
+            alert(`${data.error}（${response.headers.get('Retry-After')}秒后可以重试）`);
+        } else {
+            alert(data.error || '发送失败');
+        }
+    } catch (error) {
+        alert('网络错误，请重试');
+    }
+});
+
+// 确认邮件登录，token只能用一次，所以由用户点击按钮后再提交
+document.getElementById('magicLoginForm')?.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const token = new URLSearchParams(window.location.search).get('token');
+
+    try {
+        const response = await fetch('/api/login/magic/verify', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ token })
+        });
+
+        let data = await response.json();
+
+        if (response.ok && data.mfa_required) {
+            data = await submitMFACode(data.mfa_token);
+            if (!data) {
+                return;
+            }
+        }
+
+        if (response.ok) {
+            saveTokens(data);
+            localStorage.setItem('user', JSON.stringify(data.user));
+            window.location.href = '/dashboard';
+        } else {
+            alert(data.error || '登录失败');
+            window.location.href = '/login';
+        }
+    } catch (error) {
+        alert('网络错误，请重试');
+    }
+});
+
+// 保存登录或刷新返回的token
+function saveTokens(data) {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refreshToken', data.refresh_token);
+}
+
+function clearTokens() {
     localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
+}
+
+// 用刷新token换新的访问token，多个请求同时过期时只刷新一次
+let refreshing = null;
+function refreshAccessToken() {
+    if (!refreshing) {
+        const refreshToken = localStorage.getItem('refreshToken');
+        refreshing = (async () => {
+            if (!refreshToken) {
+                return false;
+            }
+            const response = await originalFetch('/api/token/refresh', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ refresh_token: refreshToken })
+            });
+            if (!response.ok) {
+                return false;
+            }
+            saveTokens(await response.json());
+            return true;
+        })().catch(() => false).finally(() => { refreshing = null; });
+    }
+    return refreshing;
+}
+
+// 访问token过期后自动刷新并重试一次
+const originalFetch = window.fetch.bind(window);
+window.fetch = async (url, options = {}) => {
+    const response = await originalFetch(url, options);
+    const headers = options.headers;
+    if (response.status !== 401 || !headers || !headers['Authorization'] || !(await refreshAccessToken())) {
+        return response;
+    }
+    return originalFetch(url, {
+        ...options,
+        headers: { ...headers, 'Authorization': `Bearer ${localStorage.getItem('token')}` }
+    });
+};
+
+// 退出登录，服务端同时注销当前会话
+async function logout() {
+    const token = localStorage.getItem('token');
+    if (token) {
+        await originalFetch('/api/logout', {
+            method: 'POST',
+            headers: { 'Authorization': `Bearer ${token}` }
+        }).catch(() => {});
+    }
+    clearTokens();
     localStorage.removeItem('user');
     window.location.href = '/login';
 }
@@ -139,10 +356,69 @@ async function loadRememberMeInfo(){
     }
 }
 
+// 第三方登录回调后，token、两步验证或错误信息放在URL片段中
+async function handleLoginFragment() {
+    if (window.location.pathname !== '/login' || !window.location.hash) {
+        return false;
+    }
+    const params = new URLSearchParams(window.location.hash.substring(1));
+    history.replaceState(null, '', window.location.pathname);
+
+    let data = null;
+    if (params.get('token')) {
+        data = { token: params.get('token'), refresh_token: params.get('refresh_token') };
+    } else if (params.get('mfa_token')) {
+        data = await submitMFACode(params.get('mfa_token'));
+    } else if (params.get('error')) {
+        alert(params.get('error'));
+    }
+    if (!data) {
+        return false;
+    }
+    saveTokens(data);
+    if (data.user) {
+        localStorage.setItem('user', JSON.stringify(data.user));
+    }
+    window.location.href = '/dashboard';
+    return true;
+}
+
+// 登录页按服务端配置显示可用的登录方式
+async function loadLoginMethods() {
+    const container = document.getElementById('oidcProviders');
+    if (!container) {
+        return;
+    }
+    try {
+        const response = await fetch('/api/login/methods');
+        const data = await response.json();
+        if (!data.password) {
+            document.getElementById('loginForm').style.display = 'none';
+        }
+        if (!data.magic_link) {
+            document.getElementById('magicLinkForm').style.display = 'none';
+        }
+        data.oidc.forEach(provider => {
+            const link = document.createElement('a');
+            link.className = 'btn btn-outline';
+            link.href = `/api/oidc/${encodeURIComponent(provider.name)}/login`;
+            link.textContent = `使用${provider.display_name}登录`;
+            container.appendChild(link);
+        });
+    } catch (error) {
+        console.error('加载登录方式失败:', error);
+    }
+}
+
 // 页面加载时检查认证
-document.addEventListener('DOMContentLoaded', () => {
+document.addEventListener('DOMContentLoaded', async () => {
+    if (await handleLoginFragment()) {
+        return;
+    }
     const token = localStorage.getItem('token');
     const currentPath = window.location.pathname;
+    // 无需登录的页面
+    const publicPaths = ['/login', '/register', '/forgot-password', '/reset-password', '/magic-login'];
 
     // 如果已登录，跳转到dashboard
     if (token && (currentPath === '/' || currentPath === '/login' || currentPath === '/register')) {
@@ -150,7 +426,7 @@ document.addEventListener('DOMContentLoaded', () => {
     }
     
     // 如果未登录，跳转到登录页
-    if (!token && !['/login', '/register'].includes(currentPath)) {
+    if (!token && !publicPaths.includes(currentPath)) {
         window.location.href = '/login';
     }
 
@@ -158,5 +434,6 @@ document.addEventListener('DOMContentLoaded', () => {
     if (!token && ['/login'].includes(currentPath)) {
         // 监听页面DOM加载完成事件
         loadRememberMeInfo();
+        loadLoginMethods();
     }
 });
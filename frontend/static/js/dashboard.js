@@ -49,57 +49,21 @@ async function loadRecentActivity() {
     // 这里可以添加加载最近活动的逻辑
     const recentActivityElement = document.getElementById('recentActivity');
    
-      try {
-        const response = await fetch('/api/users/lastActive', {
+    recentActivityElement.innerHTML = '';
+    try {
+        const response = await fetch('/api/activities?limit=5&type=card_send,card_use,card_revoke,friend_accept', {
             headers: getAuthHeaders()
         });
         const data = await response.json();
-        if (response.ok) {
+        if (response.ok && data.activities.length > 0) {
             var loginUser = JSON.parse(localStorage.getItem('user') || '{}');
-            data.activeCards.forEach(card => {
+            data.activities.forEach(activity => {
                 const cardElement = document.createElement('div');
                 cardElement.classList.add('card');
-                var userId=loginUser.id;
-                if(card.creator_id !== userId){
-                    if(card.transaction_type === "send"){
-                        //收到对方发的卡
-                        cardElement.innerHTML = `
-                        <h4><small>${new Date(card.transaction_at).toLocaleString()}</small></h4>
-                        <small>收到了</small>
-                        <span class="gradient-text">${card.creator_nickname}</span>
-                        <small>的</small>
-                        <span class="gradient-text">${card.card_title}</span>
-                        `;
-                    }else{
-                        //收到对方发的卡
-                        cardElement.innerHTML = `
-                        <h4><small>${new Date(card.transaction_at).toLocaleString()}</small></h4>
-                        <small>使用了</small>
-                        <span class="gradient-text">${card.creator_nickname}</span>
-                        <small>的</small>
-                        <span class="gradient-text">${card.card_title}</span>
-                        `;
-                    }
-                }else{
-                    if(card.transaction_type === "send"){
-                        //发送对方卡
-                        cardElement.innerHTML = `
-                        <h4><small>${new Date(card.transaction_at).toLocaleString()}</small></h4>
-                        <small>发送了</small>
-                        <span class="gradient-text">${card.card_title}</span>
-                        <small>给</small>
-                        <span class="gradient-text">${card.owner_nickname}</span>
-                        `;
-                    }else{
-                        //对方使用了我的卡
-                        cardElement.innerHTML = `
-                        <h4><small>${new Date(card.transaction_at).toLocaleString()}</small></h4>
-                        <span class="gradient-text">${card.owner_nickname}</span>
-                        <small>使用了你的</small>
-                        <span class="gradient-text">${card.card_title}</span>                        
-                        `;
-                    }
-                }
+                cardElement.innerHTML = `
+                    <h4><small>${new Date(activity.created_at).toLocaleString()}</small></h4>
+                    ${renderActivity(activity, loginUser.id)}
+                `;
                 recentActivityElement.appendChild(cardElement);
             });
         } else {
@@ -107,10 +71,34 @@ async function loadRecentActivity() {
             recentActivityElement.textContent = '暂无活动';
         }
     } catch (error) {
-        console.error('加载用户信息失败:', error);
+        console.error('加载最近活动失败:', error);
     }
+}
 
-
+// 根据动态类型生成描述
+function renderActivity(activity, userId) {
+    const p = activity.payload || {};
+    const mine = p.from_user_id === userId;
+    switch (activity.type) {
+        case 'card_send':
+            return mine
+                ? `<small>发送了</small> <span class="gradient-text">${p.card_title}</span> <small>给</small> <span class="gradient-text">${p.to_nickname}</span>`
+                : `<small>收到了</small> <span class="gradient-text">${p.from_nickname}</span> <small>的</small> <span class="gradient-text">${p.card_title}</span>`;
+        case 'card_use':
+            return mine
+                ? `<small>使用了</small> <span class="gradient-text">${p.creator_nickname}</span> <small>的</small> <span class="gradient-text">${p.card_title}</span>`
+                : `<span class="gradient-text">${p.from_nickname}</span> <small>使用了你的</small> <span class="gradient-text">${p.card_title}</span>`;
+        case 'card_revoke':
+            return mine
+                ? `<small>收回了送给</small> <span class="gradient-text">${p.to_nickname}</span> <small>的</small> <span class="gradient-text">${p.card_title}</span>`
+                : `<span class="gradient-text">${p.from_nickname}</span> <small>收回了</small> <span class="gradient-text">${p.card_title}</span>`;
+        case 'friend_accept':
+            return mine
+                ? `<small>和</small> <span class="gradient-text">${p.to_nickname}</span> <small>成为了道友</small>`
+                : `<span class="gradient-text">${p.from_nickname}</span> <small>接受了你的道友邀请</small>`;
+        default:
+            return `<small>${activity.type}</small>`;
+    }
 }
 
 // 加载邮件修改元素
@@ -119,6 +107,7 @@ async function loadEmailModal() {
     const emailElement = document.getElementById('showEmail');
     const modal = document.getElementById('emailModal');
     const newEmailInput = document.getElementById('newEmail');
+    const passwordInput = document.getElementById('emailPassword');
     const cancelBtn = document.getElementById('cancelBtn');
     const saveBtn = document.getElementById('saveBtn');
     const emailError = document.getElementById('emailError');
@@ -129,6 +118,7 @@ async function loadEmailModal() {
     // 点击邮箱显示弹框
     emailElement.addEventListener('click', () => {
         newEmailInput.value = currentEmail;
+        passwordInput.value = '';
         emailError.style.display = 'none';
         modal.style.display = 'flex';
         newEmailInput.focus();
@@ -178,24 +168,26 @@ async function loadEmailModal() {
             return;
         }
 
+        // 刚登录过可以不输入当前密码，通过第三方或邮件链接登录的用户不知道密码
+        const password = passwordInput.value;
+
         try {
-            var loginUser = JSON.parse(localStorage.getItem('user') || '{}');
-            loginUser.email = newEmail
             // 发送到后台
-            const response = await fetch(`/api/user/${loginUser.id}/update`, {
+            const response = await fetch('/api/account/settings', {
                 method: 'POST',
                 headers: getAuthHeaders(),
-                body: JSON.stringify({ user: loginUser })
+                body: JSON.stringify({ email: newEmail, current_password: password })
             });
             const result = await response.json();
             if (response.ok) {
+                localStorage.setItem('user', JSON.stringify(result.user));
                 // 更新页面显示
                 currentEmail = newEmail;
                 emailElement.textContent = newEmail;
                 modal.style.display = 'none';
-                alert('邮箱地址更新成功');
+                alert(result.message);
             } else {
-                showError(result.message || '更新失败，请稍后重试');
+                showError(result.error || '更新失败，请稍后重试');
             }
         } catch (error) {
             console.error('更新邮箱错误:', error);
@@ -211,11 +203,11 @@ async function loadEmailModal() {
     });
 
     // 按Enter键保存
-    newEmailInput.addEventListener('keydown', (e) => {
+    [newEmailInput, passwordInput].forEach(input => input.addEventListener('keydown', (e) => {
         if (e.key === 'Enter') {
             saveBtn.click();
         }
-    });
+    }));
 }
 
 // 加载昵称修改元素
@@ -269,23 +261,22 @@ async function loadNikNameModal() {
         }
 
         try {
-            var loginUser = JSON.parse(localStorage.getItem('user') || '{}');
-            loginUser.nickname = newNikName
             // 发送到后台
-            const response = await fetch(`/api/user/${loginUser.id}/update`, {
+            const response = await fetch('/api/account/settings', {
                 method: 'POST',
                 headers: getAuthHeaders(),
-                body: JSON.stringify({ user: loginUser })
+                body: JSON.stringify({ nickname: newNikName })
             });
             const result = await response.json();
             if (response.ok) {
+                localStorage.setItem('user', JSON.stringify(result.user));
                 // 更新页面显示
                 currentNikName = newNikName;
                 nikNameElement.textContent = newNikName;
                 modal.style.display = 'none';
                 alert('昵称更新成功');
             } else {
-                showError(result.message || '更新失败，请稍后重试');
+                showError(result.error || '更新失败，请稍后重试');
             }
         } catch (error) {
             console.error('更新昵称错误:', error);
@@ -308,11 +299,297 @@ async function loadNikNameModal() {
     });
 }
 
+// 加载登录设备
+async function loadSessions() {
+    const listElement = document.getElementById('sessionList');
+    try {
+        const response = await fetch('/api/account/sessions', { headers: getAuthHeaders() });
+        if (!response.ok) {
+            return;
+        }
+        const data = await response.json();
+        listElement.innerHTML = '';
+        data.sessions.forEach(session => {
+            const item = document.createElement('div');
+            item.style.cssText = 'display: flex; justify-content: space-between; align-items: center; padding: 0.5rem 0;';
+            item.innerHTML = `
+                <div>
+                    <span class="gradient-text">${session.device}</span>${session.current ? ' <small>（当前设备）</small>' : ''}<br>
+                    <small>${session.ip} · 最近访问 ${new Date(session.last_seen_at).toLocaleString()}</small>
+                </div>
+            `;
+            if (!session.current) {
+                const revokeBtn = document.createElement('button');
+                revokeBtn.className = 'btn btn-outline';
+                revokeBtn.textContent = '注销';
+                revokeBtn.addEventListener('click', () => revokeSession(session.id));
+                item.appendChild(revokeBtn);
+            }
+            listElement.appendChild(item);
+        });
+    } catch (error) {
+        console.error('加载登录设备失败:', error);
+    }
+}
+
+// 注销其他设备上的登录
+async function revokeSession(id) {
+    if (!confirm('确定注销该设备上的登录吗？')) {
+        return;
+    }
+    const response = await fetch(`/api/account/sessions/${id}/revoke`, {
+        method: 'POST',
+        headers: getAuthHeaders()
+    });
+    const result = await response.json();
+    if (!response.ok) {
+        alert(result.error || '注销失败，请稍后重试');
+    }
+    loadSessions();
+    loadIdentities();
+}
+
+// 退出所有设备，包括当前设备
+function loadLogoutAll() {
+    document.getElementById('logoutAllBtn').addEventListener('click', async () => {
+        if (!confirm('确定退出所有设备上的登录吗？')) {
+            return;
+        }
+        const response = await fetch('/api/logout/all', {
+            method: 'POST',
+            headers: getAuthHeaders()
+        });
+        if (response.ok) {
+            clearTokens();
+            localStorage.removeItem('user');
+            window.location.href = '/login';
+        } else {
+            const result = await response.json();
+            alert(result.error || '操作失败，请稍后重试');
+        }
+    });
+}
+
+// 注销账号，需要时再选择接收卡片的道友
+function loadDeleteAccount() {
+    document.getElementById('deleteAccountBtn').addEventListener('click', async () => {
+        if (!confirm('注销后账号无法恢复，确定要注销吗？')) {
+            return;
+        }
+        // 刚登录过可以直接注销，否则需要输入密码或重新登录
+        const body = {};
+        for (;;) {
+            const response = await fetch('/api/account/delete', {
+                method: 'POST',
+                headers: getAuthHeaders(),
+                body: JSON.stringify(body)
+            });
+            const result = await response.json();
+            if (response.ok) {
+                clearTokens();
+                localStorage.removeItem('user');
+                removeCookie('userName');
+                removeCookie('userPwd');
+                alert(result.message);
+                window.location.href = '/login';
+                return;
+            }
+            if (result.reauth_required && !body.password) {
+                body.password = prompt('注销账号需要输入密码（通过第三方或邮件链接登录的用户请重新登录后再注销）：');
+                if (!body.password) {
+                    return;
+                }
+                continue;
+            }
+            if (!result.transfer_required) {
+                alert(result.error || '注销失败，请稍后重试');
+                return;
+            }
+            body.transfer_to = prompt('请输入接收你持有卡片的道友用户名：');
+            if (!body.transfer_to) {
+                return;
+            }
+        }
+    });
+}
+
+// 两步验证设置
+function loadTOTP() {
+    const status = document.getElementById('totpStatus');
+    const setup = document.getElementById('totpSetup');
+    const setupBtn = document.getElementById('totpSetupBtn');
+    const recoveryBtn = document.getElementById('totpRecoveryBtn');
+    const disableBtn = document.getElementById('totpDisableBtn');
+
+    const render = () => {
+        const user = JSON.parse(localStorage.getItem('user') || '{}');
+        const enabled = !!user.totp_enabled_at;
+        status.textContent = enabled ? '已开启，登录时需要输入验证器中的验证码' : '未开启';
+        setupBtn.style.display = enabled ? 'none' : '';
+        recoveryBtn.style.display = enabled ? '' : 'none';
+        disableBtn.style.display = enabled ? '' : 'none';
+        setup.style.display = 'none';
+    };
+    const showRecoveryCodes = codes => {
+        alert('请妥善保存以下恢复码，每个只能使用一次，丢失验证器时可以用来登录：\n\n' + codes.join('\n'));
+    };
+    const post = async (url, body) => {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify(body || {})
+        });
+        const result = await response.json();
+        if (!response.ok) {
+            alert(result.error || '操作失败，请稍后重试');
+            return null;
+        }
+        return result;
+    };
+
+    setupBtn.addEventListener('click', async () => {
+        const result = await post('/api/account/2fa/setup');
+        if (result) {
+            document.getElementById('totpSecret').textContent = result.secret;
+            document.getElementById('totpURI').href = result.otpauth_uri;
+            setup.style.display = 'block';
+            setupBtn.style.display = 'none';
+        }
+    });
+    document.getElementById('totpEnableBtn').addEventListener('click', async () => {
+        const code = document.getElementById('totpCode').value.trim();
+        const result = await post('/api/account/2fa/enable', { code });
+        if (result) {
+            showRecoveryCodes(result.recovery_codes);
+            await loadUserInfo();
+            render();
+        }
+    });
+    recoveryBtn.addEventListener('click', async () => {
+        const password = prompt('请输入密码确认：');
+        if (!password) {
+            return;
+        }
+        const result = await post('/api/account/2fa/recovery_codes', { password });
+        if (result) {
+            showRecoveryCodes(result.recovery_codes);
+        }
+    });
+    disableBtn.addEventListener('click', async () => {
+        const password = prompt('关闭两步验证需要输入密码：');
+        if (!password) {
+            return;
+        }
+        const result = await post('/api/account/2fa/disable', { password });
+        if (result) {
+            alert(result.message);
+            await loadUserInfo();
+            render();
+        }
+    });
+
+    render();
+}
+
+// 第三方账号绑定，没有配置身份提供方时不显示
+async function loadIdentities() {
+    // 绑定完成后从身份提供方跳回，结果放在URL片段中
+    if (window.location.hash) {
+        const params = new URLSearchParams(window.location.hash.substring(1));
+        history.replaceState(null, '', window.location.pathname);
+        if (params.get('error')) {
+            alert(params.get('error'));
+        } else if (params.get('linked')) {
+            alert('绑定成功');
+        }
+    }
+
+    const response = await fetch('/api/account/identities', { headers: getAuthHeaders() });
+    if (!response.ok) {
+        return;
+    }
+    const data = await response.json();
+    if (data.providers.length === 0 && data.identities.length === 0) {
+        return;
+    }
+    document.getElementById('identityCard').style.display = 'block';
+    const listElement = document.getElementById('identityList');
+    listElement.innerHTML = '';
+
+    data.providers.forEach(provider => {
+        const item = document.createElement('div');
+        item.style.cssText = 'display: flex; justify-content: space-between; align-items: center; padding: 0.5rem 0;';
+        const linked = data.identities.filter(identity => identity.provider === provider.name);
+        const label = document.createElement('div');
+        label.innerHTML = `<span class="gradient-text"></span><br><small></small>`;
+        label.querySelector('span').textContent = provider.display_name;
+        label.querySelector('small').textContent = linked.length ? linked.map(identity => identity.email).join('、') : '未绑定';
+        item.appendChild(label);
+
+        const button = document.createElement('button');
+        button.className = 'btn btn-outline';
+        if (linked.length) {
+            button.textContent = '解除绑定';
+            button.addEventListener('click', async () => {
+                if (!confirm(`确定解除${provider.display_name}的绑定吗？`)) {
+                    return;
+                }
+                for (const identity of linked) {
+                    await fetch(`/api/account/identities/${identity.id}/unlink`, { method: 'POST', headers: getAuthHeaders() });
+                }
+                loadIdentities();
+            });
+        } else {
+            button.textContent = '绑定';
+            button.addEventListener('click', async () => {
+                const result = await fetch(`/api/oidc/${encodeURIComponent(provider.name)}/link`, {
+                    method: 'POST',
+                    headers: getAuthHeaders()
+                }).then(r => r.json());
+                if (result.url) {
+                    window.location.href = result.url;
+                } else {
+                    alert(result.error || '绑定失败，请稍后重试');
+                }
+            });
+        }
+        item.appendChild(button);
+        listElement.appendChild(item);
+    });
+}
+
 // 页面加载
+// 订阅实时事件，收到卡片或道友事件时刷新统计和最近活动
+function subscribeEvents() {
+    const token = localStorage.getItem('token');
+    if (!token || !window.EventSource) {
+        return;
+    }
+    const source = new EventSource(`/api/events/stream?access_token=${encodeURIComponent(token)}`);
+    const refresh = () => {
+        loadStats();
+        loadRecentActivity();
+    };
+    ['card_send', 'card_use', 'card_revoke', 'card_expire', 'friend_invite', 'friend_accept'].forEach(type => {
+        source.addEventListener(type, refresh);
+    });
+    // 访问token过期后连接会被拒绝，刷新token后重新订阅
+    source.onerror = async () => {
+        if (source.readyState === EventSource.CLOSED && await refreshAccessToken()) {
+            subscribeEvents();
+        }
+    };
+}
+
 document.addEventListener('DOMContentLoaded', () => {
-    loadUserInfo();
+    loadUserInfo().then(loadTOTP);
     loadStats();
     loadRecentActivity();
     loadEmailModal();
     loadNikNameModal();
+    loadSessions();
+    loadIdentities();
+    loadLogoutAll();
+    loadDeleteAccount();
+    subscribeEvents();
 });